@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/labstack/echo"
+)
+
+// RestoreResult is the response to a database restore
+type RestoreResult struct {
+	Format string `json:"format"` // bolt or json
+	Groups int    `json:"groups"`
+	Days   int    `json:"days"`
+	Dates  int    `json:"dates"`
+}
+
+// PurgeResult is the response to a purge of orphaned schedules
+type PurgeResult struct {
+	Groups []string `json:"groups"` // IDs of the missing groups
+}
+
+// backupHandler streams a consistent snapshot of the database,
+// taken within a read transaction
+func backupHandler(ctx *echo.Context) error {
+	return dbFromContext(ctx).View(func(tx *bolt.Tx) error {
+		name := fmt.Sprintf("ipc-%s.db", time.Now().UTC().Format("20060102T150405Z"))
+
+		h := ctx.Response().Header()
+		h.Set("Content-Type", "application/octet-stream")
+		h.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+		h.Set("Content-Length", fmt.Sprintf("%d", tx.Size()))
+		ctx.Response().WriteHeader(200)
+
+		_, err := tx.WriteTo(ctx.Response())
+		if err != nil {
+			Log.Error("Failed to write backup", "error", err)
+		}
+		return err
+	})
+}
+
+// restoreHandler replaces the entire database with the uploaded
+// database, either a BoltDB file (as from backupHandler, for the
+// Bolt store only) or a DatabaseDump (as from dumpHandler), given as
+// the request body or the multipart `file`.  The upload is validated
+// before anything is replaced, and the replacement is made within a
+// single transaction.
+func restoreHandler(ctx *echo.Context) error {
+	req := ctx.Request()
+
+	var input io.Reader = req.Body
+	if mediaType(req.Header.Get("Content-Type")) == "multipart/form-data" {
+		f, _, err := req.FormFile("file")
+		if err != nil {
+			return ctx.String(400, err.Error())
+		}
+		defer f.Close()
+		input = f
+	}
+
+	// JSON dumps begin with an object; BoltDB files never do
+	r := bufio.NewReader(input)
+	isJSON := false
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			break
+		}
+		if b == ' ' || b == '\t' || b == '\r' || b == '\n' {
+			continue
+		}
+		isJSON = b == '{'
+		r.UnreadByte()
+		break
+	}
+
+	var ret *RestoreResult
+	var err error
+	if isJSON {
+		var dump *DatabaseDump
+		if dump, err = readDump(r); err != nil {
+			return ctx.String(400, err.Error())
+		}
+		ret, err = restoreDump(storeFromContext(ctx), dump)
+	} else {
+		db := dbFromContext(ctx)
+		if db == nil {
+			return ctx.String(400, "Only JSON dumps may be restored to a SQLite store")
+		}
+		ret, err = restoreBolt(db, r)
+		if c, ok := storeFromContext(ctx).(*scheduleCache); ok {
+			c.invalidate("")
+		}
+	}
+	if err != nil {
+		if _, ok := err.(invalidRestoreError); ok {
+			return ctx.String(400, err.Error())
+		}
+		return ctx.String(500, err.Error())
+	}
+
+	Log.Info("Restored database", "format", ret.Format, "groups", ret.Groups, "days", ret.Days, "dates", ret.Dates)
+	return ctx.JSON(200, ret)
+}
+
+// purgeOrphansHandler deletes every schedule whose group does not
+// exist, as left behind by deleting groups before their schedules
+// were deleted with them
+func purgeOrphansHandler(ctx *echo.Context) error {
+	ids, err := storeFromContext(ctx).PurgeOrphans()
+	if err != nil {
+		Log.Error("Failed to purge orphaned schedules", "error", err)
+		return ctx.String(500, err.Error())
+	}
+	Log.Info("Purged orphaned schedules", "groups", ids)
+	return ctx.JSON(200, &PurgeResult{Groups: ids})
+}
+
+// invalidRestoreError indicates that an uploaded database is
+// not valid, and nothing was restored
+type invalidRestoreError struct {
+	error
+}
+
+// restoreBolt validates the given BoltDB file and replaces the
+// contents of the database with its contents
+func restoreBolt(db *bolt.DB, r io.Reader) (*RestoreResult, error) {
+	// Bolt requires a file
+	f, err := ioutil.TempFile("", "ipc-restore")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	_, err = io.Copy(f, r)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := bolt.Open(f.Name(), 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return nil, invalidRestoreError{fmt.Errorf("Not a schedule database: %s", err.Error())}
+	}
+	defer src.Close()
+
+	ret := &RestoreResult{Format: "bolt"}
+	err = src.View(func(stx *bolt.Tx) error {
+		if err := validateDatabase(stx, ret); err != nil {
+			return invalidRestoreError{err}
+		}
+
+		return db.Update(func(tx *bolt.Tx) error {
+			if err := clearDatabase(tx); err != nil {
+				return err
+			}
+			err := stx.ForEach(func(name []byte, b *bolt.Bucket) error {
+				dst, err := tx.CreateBucketIfNotExists(name)
+				if err != nil {
+					return err
+				}
+				return copyBucket(dst, b)
+			})
+			if err != nil {
+				return err
+			}
+			return migrateWithTx(tx)
+		})
+	})
+	return ret, err
+}
+
+// validateDatabase checks that every group, and every Day and Date
+// of each group, of the given database can be decoded, counting
+// them in ret
+func validateDatabase(tx *bolt.Tx, ret *RestoreResult) error {
+	if _, err := checkSchemaVersion(tx); err != nil {
+		return err
+	}
+	groups := tx.Bucket(groupBucket)
+	if groups == nil {
+		return fmt.Errorf("Not a schedule database: no groups")
+	}
+	return groups.ForEach(func(k, v []byte) error {
+		var g Group
+		if err := decodeGroup(v, &g); err != nil {
+			return fmt.Errorf("Failed to decode group %s: %s", k, err.Error())
+		}
+		ret.Groups++
+
+		b := tx.Bucket(g.Key())
+		if b == nil {
+			return nil
+		}
+		if days := b.Bucket(daysBucket); days != nil {
+			err := days.ForEach(func(k, v []byte) error {
+				var d Day
+				if err := decodeDay(v, &d); err != nil {
+					return fmt.Errorf("Failed to decode day %s of group %s: %s", k, g.ID, err.Error())
+				}
+				ret.Days++
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if dates := b.Bucket(datesBucket); dates != nil {
+			err := dates.ForEach(func(k, v []byte) error {
+				var d Date
+				if err := decodeDate(v, &d); err != nil {
+					return fmt.Errorf("Failed to decode date %s of group %s: %s", k, g.ID, err.Error())
+				}
+				ret.Dates++
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// restoreDump validates the given dump and replaces the contents
+// of the store with it
+func restoreDump(s Store, dump *DatabaseDump) (*RestoreResult, error) {
+	ret := &RestoreResult{Format: "json"}
+
+	// Validate the dump
+	var schedules []*ScheduleDump
+	seen := make(map[string]bool)
+	for i, gd := range dump.Groups {
+		sd, err := gd.schedule()
+		if err != nil {
+			return nil, invalidRestoreError{fmt.Errorf("Group %d: %s", i+1, err.Error())}
+		}
+		if seen[sd.Group.ID] {
+			return nil, invalidRestoreError{fmt.Errorf("Group %s appears more than once", sd.Group.ID)}
+		}
+		seen[sd.Group.ID] = true
+		schedules = append(schedules, sd)
+
+		ret.Groups++
+		ret.Days += len(sd.Days)
+		ret.Dates += len(sd.Dates)
+	}
+
+	if err := s.ReplaceAll(schedules); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// loadSchedules saves every group, Day, and Date of the given
+// schedules
+func loadSchedules(tx *bolt.Tx, schedules []*ScheduleDump) error {
+	for _, s := range schedules {
+		data, err := encodeGroup(s.Group)
+		if err != nil {
+			return err
+		}
+		if err = tx.Bucket(groupBucket).Put(s.Group.Key(), data); err != nil {
+			return err
+		}
+		if err = saveDaysWithTx(tx, s.Group, s.Days); err != nil {
+			return err
+		}
+		if err = saveDatesWithTx(tx, s.Group, s.Dates); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clearDatabase deletes every top-level bucket of the database
+func clearDatabase(tx *bolt.Tx) error {
+	var names [][]byte
+	err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+		names = append(names, append([]byte(nil), name...))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err = tx.DeleteBucket(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyBucket copies every key, and every nested bucket, of src
+// into dst
+func copyBucket(dst, src *bolt.Bucket) error {
+	return src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return dst.Put(k, v)
+		}
+		sub, err := dst.CreateBucketIfNotExists(k)
+		if err != nil {
+			return err
+		}
+		return copyBucket(sub, src.Bucket(k))
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBackupRestore(t *testing.T) {
+	src, err := dbOpen("./backupTest.db")
+	if err != nil {
+		panic("Failed to open test database")
+	}
+	dst, err := dbOpen("./restoreTest.db")
+	if err != nil {
+		panic("Failed to open test database")
+	}
+	defer func() {
+		src.Close()
+		dst.Close()
+		os.Remove("./backupTest.db")
+		os.Remove("./restoreTest.db")
+	}()
+
+	g := Group{ID: "testBackupGroup", Name: "testBackupGroup", Location: locString}
+	saveGroup(src, &g)
+	src.Update(func(tx *bolt.Tx) error {
+		day := Day{Group: g.ID, Target: "200", Day: time.Saturday, Start: time.Hour, Duration: 4 * time.Hour, Location: g.Location}
+		date := Date{Group: g.ID, Target: "300", Date: time.Date(2016, 01, 30, 1, 0, 0, 0, loc), Time: 4 * time.Hour}
+		if err := day.Save(tx); err != nil {
+			return err
+		}
+		return date.Save(tx)
+	})
+
+	other := Group{ID: "testOtherGroup", Name: "testOtherGroup", Location: locString}
+	saveGroup(dst, &other)
+
+	Convey("Given a backup of a database", t, func() {
+		var backup bytes.Buffer
+		err := src.View(func(tx *bolt.Tx) error {
+			_, err := tx.WriteTo(&backup)
+			return err
+		})
+		So(err, ShouldBeNil)
+
+		Convey("Restoring it should replace the contents of another database", func() {
+			ret, err := restoreBolt(dst, &backup)
+			So(err, ShouldBeNil)
+			So(ret.Groups, ShouldEqual, 1)
+			So(ret.Days, ShouldEqual, 1)
+			So(ret.Dates, ShouldEqual, 1)
+
+			_, err = getGroup(dst, other.ID)
+			So(err, ShouldEqual, ErrNotFound)
+			restored, err := getGroup(dst, g.ID)
+			So(err, ShouldBeNil)
+			So(getTarget(newBoltStore(dst), restored.ID, time.Date(2016, 01, 30, 2, 0, 0, 0, loc)), ShouldEqual, "300")
+			So(getTarget(newBoltStore(dst), restored.ID, time.Date(2016, 02, 6, 2, 0, 0, 0, loc)), ShouldEqual, "200")
+		})
+	})
+
+	Convey("Restoring a file which is not a database should fail without changes", t, func() {
+		saveGroup(dst, &other)
+		_, err := restoreBolt(dst, strings.NewReader("not a database"))
+		So(err, ShouldNotBeNil)
+		_, ok := err.(invalidRestoreError)
+		So(ok, ShouldBeTrue)
+		_, err = getGroup(dst, other.ID)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("Given a JSON dump", t, func() {
+		dump := &DatabaseDump{Version: dumpVersion, Groups: []*GroupDump{
+			&GroupDump{
+				ID:       "testDumpGroup",
+				Timezone: locString,
+				Days:     []DayDump{DayDump{Day: "Saturday", Start: "01:00", Duration: "4h0m0s", Target: "200"}},
+			},
+		}}
+
+		Convey("Restoring it should replace the contents of the database", func() {
+			ret, err := restoreDump(newBoltStore(dst), dump)
+			So(err, ShouldBeNil)
+			So(ret.Groups, ShouldEqual, 1)
+			So(ret.Days, ShouldEqual, 1)
+
+			_, err = getGroup(dst, other.ID)
+			So(err, ShouldEqual, ErrNotFound)
+			So(getTarget(newBoltStore(dst), "testDumpGroup", time.Date(2016, 02, 6, 2, 0, 0, 0, loc)), ShouldEqual, "200")
+		})
+
+		Convey("A dump with an invalid time zone should be rejected", func() {
+			dump.Groups[0].Timezone = "Mars/Olympus_Mons"
+			_, err := restoreDump(newBoltStore(dst), dump)
+			So(err, ShouldNotBeNil)
+			_, ok := err.(invalidRestoreError)
+			So(ok, ShouldBeTrue)
+		})
+	})
+}
@@ -2,12 +2,12 @@ package main
 
 import (
 	"net"
+	"time"
 
 	"github.com/CyCoreSystems/agi"
-	"github.com/boltdb/bolt"
 )
 
-func fastAGI(db *bolt.DB) {
+func fastAGI(s Store) {
 	l, err := net.Listen("tcp", agiaddr)
 	if err != nil {
 		panic("Cannot listen on FastAGI address " + agiaddr)
@@ -21,11 +21,11 @@ func fastAGI(db *bolt.DB) {
 		}
 
 		Log.Debug("New AGI connection", "address", conn.RemoteAddr)
-		go handleAGI(db, conn)
+		go handleAGI(s, conn)
 	}
 }
 
-func handleAGI(db *bolt.DB, c net.Conn) {
+func handleAGI(s Store, c net.Conn) {
 	defer c.Close()
 
 	a := agi.New(c, c)
@@ -36,8 +36,32 @@ func handleAGI(db *bolt.DB, c net.Conn) {
 		return
 	}
 
-	Log.Debug("Loading target for AGI", "group", exten)
-	t := getTarget(db, exten)
+	// IPC_AT optionally specifies the (RFC3339) time at which
+	// to resolve the schedule
+	at, err := a.Get("IPC_AT")
+	if err != nil {
+		Log.Debug("No IPC_AT variable from AGI; using current time", "error", err)
+		at = ""
+	}
+	when, err := parseAt(at)
+	if err != nil {
+		// Route the call anyway, rather than drop it
+		Log.Error("Failed to parse IPC_AT variable from AGI; using current time", "at", at, "error", err)
+		when = time.Now()
+	}
+
+	Log.Debug("Loading target for AGI", "group", exten, "at", when)
+	t := getTarget(s, exten, when)
+
+	if agiTrace {
+		tr, err := explainTarget(s, exten, when)
+		if err != nil {
+			Log.Debug("Failed to trace target resolution", "group", exten, "error", err)
+		} else {
+			Log.Debug("Target resolution trace", "group", exten, "trace", tr)
+		}
+	}
+
 	err = a.Set("IPC_TARGET", t)
 	if err != nil {
 		Log.Error("Failed to set IPC-TARGET on AGI", "target", t, "error", err)
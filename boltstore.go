@@ -0,0 +1,287 @@
+package main
+
+import (
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// boltStore is the Store backed by a BoltDB database
+type boltStore struct {
+	db *bolt.DB
+
+	// tx is the transaction of Update, if any
+	tx *bolt.Tx
+}
+
+// newBoltStore returns the Store backed by the given database
+func newBoltStore(db *bolt.DB) *boltStore {
+	return &boltStore{db: db}
+}
+
+// view runs fn within the transaction of the store, if it has one,
+// or else within a new read-only transaction
+func (s *boltStore) view(fn func(tx *bolt.Tx) error) error {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+	return s.db.View(fn)
+}
+
+// update runs fn within the transaction of the store, if it has
+// one, or else within a new read-write transaction
+func (s *boltStore) update(fn func(tx *bolt.Tx) error) error {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+	return s.db.Update(fn)
+}
+
+func (s *boltStore) Groups() ([]*Group, error) {
+	list := []*Group{}
+	err := s.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(groupBucket).ForEach(func(k, v []byte) error {
+			var g Group
+			if err := decodeGroup(v, &g); err != nil {
+				return err
+			}
+			list = append(list, &g)
+			return nil
+		})
+	})
+	return list, err
+}
+
+func (s *boltStore) Group(id string) (g *Group, err error) {
+	err = s.view(func(tx *bolt.Tx) error {
+		g, err = getGroupWithTx(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func (s *boltStore) SaveGroup(g *Group) error {
+	data, err := encodeGroup(g)
+	if err != nil {
+		return err
+	}
+	return s.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(groupBucket).Put(g.Key(), data)
+	})
+}
+
+func (s *boltStore) DeleteGroup(id string) error {
+	if id == "" {
+		return errEmptyID
+	}
+	g := &Group{ID: id}
+	return s.update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(groupBucket).Delete(g.Key()); err != nil {
+			return err
+		}
+		// The schedule of the group is its own bucket
+		if isReservedBucket(g.Key()) || tx.Bucket(g.Key()) == nil {
+			return nil
+		}
+		return tx.DeleteBucket(g.Key())
+	})
+}
+
+func (s *boltStore) Days(g *Group) (ret []Day, err error) {
+	err = s.view(func(tx *bolt.Tx) error {
+		// A group without a bucket has no schedule
+		if ret, err = daysForGroupWithTx(tx, g); err != nil {
+			Log.Debug("No days for group", "group", g.ID, "error", err)
+		}
+		return nil
+	})
+	return
+}
+
+func (s *boltStore) Dates(g *Group) (ret []Date, err error) {
+	err = s.view(func(tx *bolt.Tx) error {
+		// A group without a bucket has no schedule
+		if ret, err = datesForGroupWithTx(tx, g); err != nil {
+			Log.Debug("No dates for group", "group", g.ID, "error", err)
+		}
+		return nil
+	})
+	return
+}
+
+func (s *boltStore) ReplaceDays(g *Group, days []Day) error {
+	return s.update(func(tx *bolt.Tx) error {
+		if err := g.ClearDays(tx); err != nil {
+			return err
+		}
+		return saveDaysWithTx(tx, g, days)
+	})
+}
+
+func (s *boltStore) ReplaceDates(g *Group, dates []Date) error {
+	return s.update(func(tx *bolt.Tx) error {
+		if err := g.ClearDates(tx); err != nil {
+			return err
+		}
+		return saveDatesWithTx(tx, g, dates)
+	})
+}
+
+func (s *boltStore) MergeDays(g *Group, days []Day) error {
+	return s.update(func(tx *bolt.Tx) error {
+		return saveDaysWithTx(tx, g, days)
+	})
+}
+
+func (s *boltStore) MergeDates(g *Group, dates []Date) error {
+	return s.update(func(tx *bolt.Tx) error {
+		return saveDatesWithTx(tx, g, dates)
+	})
+}
+
+func (s *boltStore) PutDay(g *Group, d Day) error {
+	return s.update(func(tx *bolt.Tx) error {
+		return saveDaysWithTx(tx, g, []Day{d})
+	})
+}
+
+func (s *boltStore) DeleteDay(g *Group, key string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(g.Key())
+		if b == nil || isReservedBucket(g.Key()) {
+			return ErrNotFound
+		}
+		if b = b.Bucket(daysBucket); b == nil || b.Get([]byte(key)) == nil {
+			return ErrNotFound
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+func (s *boltStore) DatesBetween(g *Group, from, to time.Time) (ret []Date, err error) {
+	err = s.view(func(tx *bolt.Tx) error {
+		ret, err = datesBetweenWithTx(tx, g, from, to)
+		return err
+	})
+	return
+}
+
+func (s *boltStore) PutDate(g *Group, d Date) error {
+	return s.update(func(tx *bolt.Tx) error {
+		return saveDatesWithTx(tx, g, []Date{d})
+	})
+}
+
+// DeleteDate leaves the recorded span of the Dates of the group,
+// which remains an upper bound
+func (s *boltStore) DeleteDate(g *Group, key string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(g.Key())
+		if b == nil || isReservedBucket(g.Key()) {
+			return ErrNotFound
+		}
+		if b = b.Bucket(datesBucket); b == nil || b.Get([]byte(key)) == nil {
+			return ErrNotFound
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+func (s *boltStore) ActiveDay(g *Group, t time.Time) (*Day, error) {
+	days, err := s.Days(g)
+	if err != nil {
+		return nil, err
+	}
+	return activeDay(days, t), nil
+}
+
+func (s *boltStore) ActiveDate(g *Group, t time.Time) (d *Date, err error) {
+	err = s.view(func(tx *bolt.Tx) error {
+		d, err = activeDateWithTx(tx, g, t)
+		return err
+	})
+	return
+}
+
+// ReplaceAll deletes every bucket of the database, including any
+// which are not known to this version, before loading the schedules
+func (s *boltStore) ReplaceAll(schedules []*ScheduleDump) error {
+	return s.update(func(tx *bolt.Tx) error {
+		if err := clearDatabase(tx); err != nil {
+			return err
+		}
+		if err := createBuckets(tx); err != nil {
+			return err
+		}
+		if err := setSchemaVersion(tx, latestSchemaVersion()); err != nil {
+			return err
+		}
+		return loadSchedules(tx, schedules)
+	})
+}
+
+func (s *boltStore) PurgeOrphans() ([]string, error) {
+	ids := []string{}
+	err := s.update(func(tx *bolt.Tx) error {
+		groups := tx.Bucket(groupBucket)
+		err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if !isReservedBucket(name) && groups.Get(name) == nil {
+				ids = append(ids, string(name))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err = tx.DeleteBucket([]byte(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (s *boltStore) Update(fn func(s Store) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(&boltStore{db: s.db, tx: tx})
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+// saveDaysWithTx saves each of the Days to the group
+func saveDaysWithTx(tx *bolt.Tx, g *Group, days []Day) error {
+	for i := range days {
+		d := days[i]
+		d.Group = g.ID
+		if err := d.Save(tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveDatesWithTx saves each of the Dates to the group
+func saveDatesWithTx(tx *bolt.Tx, g *Group, dates []Date) error {
+	for i := range dates {
+		d := dates[i]
+		d.Group = g.ID
+		if err := d.Save(tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// commandTimeout is how long a command waits for the database,
+// which is locked while the service is running
+var commandTimeout = 5 * time.Second
+
+// commands are the subcommands of ipc-schedule, which are run
+// against the database instead of starting the service
+var commands = map[string]func(s Store, args []string) error{
+	"dump":      dumpCommand,
+	"load":      loadCommand,
+	"to-sqlite": toSQLiteCommand,
+}
+
+// usage prints the usage of ipc-schedule
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [flags] [command]\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	fmt.Fprintf(os.Stderr, "  dump [file]\tWrite a JSON dump of every group and its schedule to the file (or stdout)\n")
+	fmt.Fprintf(os.Stderr, "  load [file]\tReplace the database with the JSON dump in the file (or stdin)\n")
+	fmt.Fprintf(os.Stderr, "  to-sqlite file\tCopy every group and its schedule to the SQLite database file, replacing its contents\n\n")
+	fmt.Fprintf(os.Stderr, "Flags:\n")
+	flag.PrintDefaults()
+}
+
+// runCommand runs the given command, returning the exit status
+func runCommand(args []string) int {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %s\n", args[0])
+		usage()
+		return 2
+	}
+
+	s, err := openCommandStore()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open schedule database %s: %s\n", dbFile, err.Error())
+		return 1
+	}
+	defer s.Close()
+
+	if err = cmd(s, args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", args[0], err.Error())
+		return 1
+	}
+	return 0
+}
+
+// openCommandStore opens the Store selected by the -store and -db
+// flags, waiting at most commandTimeout for the database
+func openCommandStore() (Store, error) {
+	switch storeType {
+	case "bolt":
+		handle, err := bolt.Open(dbFile, 0660, &bolt.Options{Timeout: commandTimeout})
+		if err != nil {
+			return nil, fmt.Errorf("%s (is the service running?)", err.Error())
+		}
+		if err = migrate(handle); err != nil {
+			handle.Close()
+			return nil, fmt.Errorf("failed to migrate: %s", err.Error())
+		}
+		return newBoltStore(handle), nil
+	case "sqlite":
+		return sqlOpen(dbFile)
+	}
+	return nil, fmt.Errorf("unknown store %s", storeType)
+}
+
+// dumpCommand writes a DatabaseDump to the named file or, if none
+// is named, to stdout
+func dumpCommand(s Store, args []string) error {
+	dump, err := dumpDatabase(s, time.Now())
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if len(args) == 0 || args[0] == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return ioutil.WriteFile(args[0], data, 0640)
+}
+
+// loadCommand replaces the contents of the database with the
+// DatabaseDump in the named file or, if none is named, from stdin
+func loadCommand(s Store, args []string) error {
+	var r io.Reader = os.Stdin
+	if len(args) > 0 && args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	dump, err := readDump(r)
+	if err != nil {
+		return err
+	}
+	ret, err := restoreDump(s, dump)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Loaded %d groups, %d days, and %d dates\n", ret.Groups, ret.Days, ret.Dates)
+	return nil
+}
+
+// toSQLiteCommand copies every group and its schedule to the named
+// SQLite database, which is created if it does not exist, replacing
+// its contents.  This migrates a BoltDB database to SQLite in one
+// shot.
+func toSQLiteCommand(s Store, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no SQLite database given")
+	}
+
+	dump, err := dumpDatabase(s, time.Now())
+	if err != nil {
+		return err
+	}
+
+	dst, err := sqlOpen(args[0])
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	ret, err := restoreDump(dst, dump)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Copied %d groups, %d days, and %d dates to %s\n", ret.Groups, ret.Days, ret.Dates, args[0])
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCommands(t *testing.T) {
+	orig := dbFile
+	dbFile = "./commandTest.db"
+	defer func() {
+		dbFile = orig
+		os.Remove("./commandTest.db")
+		os.Remove("./commandTest.json")
+		os.Remove("./commandTest.sqlite")
+		os.Remove("./commandTest.sqlite-wal")
+		os.Remove("./commandTest.sqlite-shm")
+	}()
+
+	db, err := dbOpen(dbFile)
+	if err != nil {
+		panic("Failed to open test database")
+	}
+	saveGroup(db, &Group{ID: "testCommandGroup", Location: locString})
+	db.Close()
+
+	Convey("Unknown commands should fail", t, func() {
+		So(runCommand([]string{"frobnicate"}), ShouldEqual, 2)
+	})
+
+	Convey("A dump should be loaded back unchanged", t, func() {
+		So(runCommand([]string{"dump", "./commandTest.json"}), ShouldEqual, 0)
+		data, err := ioutil.ReadFile("./commandTest.json")
+		So(err, ShouldBeNil)
+		So(string(data), ShouldContainSubstring, `"id": "testCommandGroup"`)
+
+		So(runCommand([]string{"load", "./commandTest.json"}), ShouldEqual, 0)
+		db, err := dbOpen(dbFile)
+		So(err, ShouldBeNil)
+		defer db.Close()
+		_, err = getGroup(db, "testCommandGroup")
+		So(err, ShouldBeNil)
+	})
+
+	Convey("Copying to SQLite should preserve the groups", t, func() {
+		So(runCommand([]string{"to-sqlite"}), ShouldEqual, 1)
+		So(runCommand([]string{"to-sqlite", "./commandTest.sqlite"}), ShouldEqual, 0)
+
+		s, err := sqlOpen("./commandTest.sqlite")
+		So(err, ShouldBeNil)
+		defer s.Close()
+		_, err = s.Group("testCommandGroup")
+		So(err, ShouldBeNil)
+	})
+
+	Convey("Loading a missing file should fail", t, func() {
+		So(runCommand([]string{"load", "./missing.json"}), ShouldEqual, 1)
+	})
+}
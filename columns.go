@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Fields of a schedule CSV, in the order of the positional format
+var (
+	dayFields  = []string{"group", "day", "start", "stop", "target"}
+	dateFields = []string{"group", "date", "start", "stop", "target"}
+)
+
+// columnAliases are the (normalized) header names recognized for
+// each field of a schedule CSV
+var columnAliases = map[string][]string{
+	"group":  {"group", "groupid", "groupname", "team"},
+	"day":    {"day", "dayofweek", "dayoftheweek", "weekday", "dow"},
+	"date":   {"date", "startdate", "day"},
+	"start":  {"start", "starttime", "from", "begin", "begintime"},
+	"stop":   {"stop", "stoptime", "end", "endtime", "to", "until"},
+	"target": {"target", "targetphonenumber", "cell", "phone", "phonenumber", "number", "destination"},
+}
+
+var headerParens = regexp.MustCompile(`\([^)]*\)`)
+var headerNonAlnum = regexp.MustCompile(`[^a-z0-9]`)
+
+// normalizeHeader reduces a header name to lowercase letters and
+// digits, ignoring any parenthetical, such that "Start Time (HH:MM)"
+// becomes "starttime"
+func normalizeHeader(name string) string {
+	name = headerParens.ReplaceAllString(strings.ToLower(name), "")
+	return headerNonAlnum.ReplaceAllString(name, "")
+}
+
+// csvColumns locates the fields of a schedule CSV within its rows
+type csvColumns struct {
+	fields []string       // fields, in positional order
+	index  map[string]int // column of each field; nil for the positional format
+}
+
+// newCSVColumns determines the columns of a schedule CSV from its
+// first row and the optional column mapping.  The mapping is a
+// comma-separated list of `field:column` pairs, where the column is
+// either a header name or a 1-based column number.  If the first row
+// is a header, it is reported as such.  Otherwise, fields which are
+// not mapped take their positional columns.
+func newCSVColumns(fields []string, mapping string, first []string) (cols *csvColumns, isHeader bool, err error) {
+	cols = &csvColumns{fields: fields}
+
+	// Look for a header
+	headers := make(map[string]int)
+	for i, name := range first {
+		if n := normalizeHeader(name); n != "" {
+			if _, ok := headers[n]; !ok {
+				headers[n] = i
+			}
+		}
+	}
+	fromHeader := make(map[string]int)
+	for _, f := range fields {
+		for _, alias := range columnAliases[f] {
+			if i, ok := headers[alias]; ok {
+				fromHeader[f] = i
+				break
+			}
+		}
+	}
+	isHeader = len(fromHeader) == len(fields)
+
+	if mapping == "" {
+		if isHeader {
+			cols.index = fromHeader
+		}
+		return
+	}
+
+	// Apply the explicit mapping
+	cols.index = make(map[string]int)
+	for _, pair := range strings.Split(mapping, ",") {
+		pieces := strings.SplitN(pair, ":", 2)
+		if len(pieces) != 2 {
+			return nil, false, fmt.Errorf("Column mapping %s is not of the form field:column", pair)
+		}
+		f := strings.ToLower(strings.TrimSpace(pieces[0]))
+		if _, ok := columnAliases[f]; !ok || !hasField(fields, f) {
+			return nil, false, fmt.Errorf("Unknown field %s in column mapping", f)
+		}
+		col := strings.TrimSpace(pieces[1])
+		if n, err := strconv.Atoi(col); err == nil {
+			if n < 1 {
+				return nil, false, fmt.Errorf("Column number for %s must be at least 1", f)
+			}
+			cols.index[f] = n - 1
+			continue
+		}
+
+		// Named columns require a header
+		isHeader = true
+		i, ok := headers[normalizeHeader(col)]
+		if !ok {
+			return nil, false, fmt.Errorf("Column %s for %s not found in header", col, f)
+		}
+		cols.index[f] = i
+	}
+
+	// Fill in the unmapped fields from the header or their positions
+	for i, f := range fields {
+		if _, ok := cols.index[f]; ok {
+			continue
+		}
+		if j, ok := fromHeader[f]; ok && isHeader {
+			cols.index[f] = j
+			continue
+		}
+		cols.index[f] = i
+	}
+	return
+}
+
+// row returns the fields of the given CSV row in positional order
+func (c *csvColumns) row(rec []string) ([]string, error) {
+	if c.index == nil {
+		return rec, nil
+	}
+	ret := make([]string, len(c.fields))
+	for i, f := range c.fields {
+		j := c.index[f]
+		if j >= len(rec) {
+			return nil, fmt.Errorf("Row has no column %d for %s", j+1, f)
+		}
+		ret[i] = strings.TrimSpace(rec[j])
+	}
+	return ret, nil
+}
+
+func hasField(fields []string, f string) bool {
+	for _, v := range fields {
+		if v == f {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNormalizeHeader(t *testing.T) {
+	Convey("Given 'Start Time (HH:MM)'", t, func() {
+		So(normalizeHeader("Start Time (HH:MM)"), ShouldEqual, "starttime")
+	})
+	Convey("Given 'Group_ID'", t, func() {
+		So(normalizeHeader("Group_ID"), ShouldEqual, "groupid")
+	})
+}
+
+func TestNewCSVColumns(t *testing.T) {
+	Convey("Given a positional data row", t, func() {
+		first := []string{"9998", "M", "09:00", "23:00", "1234"}
+		cols, isHeader, err := newCSVColumns(dayFields, "", first)
+
+		Convey("The positional format should be used", func() {
+			So(err, ShouldBeNil)
+			So(isHeader, ShouldBeFalse)
+			So(cols.index, ShouldBeNil)
+			rec, err := cols.row(first)
+			So(err, ShouldBeNil)
+			So(rec, ShouldResemble, first)
+		})
+	})
+
+	Convey("Given a header row with reordered and extra columns", t, func() {
+		first := []string{"Notes", "Cell", "Day of the Week", "Group ID", "End", "Start Time (HH:MM)"}
+		cols, isHeader, err := newCSVColumns(dayFields, "", first)
+
+		Convey("The header should be recognized and the fields located", func() {
+			So(err, ShouldBeNil)
+			So(isHeader, ShouldBeTrue)
+			rec, err := cols.row([]string{"on call", "1234", "M", "9998", "23:00", "09:00"})
+			So(err, ShouldBeNil)
+			So(rec, ShouldResemble, []string{"9998", "M", "09:00", "23:00", "1234"})
+		})
+
+		Convey("A short row should fail", func() {
+			_, err := cols.row([]string{"on call", "1234"})
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a header row with unrecognized names and a column mapping", t, func() {
+		first := []string{"Team", "When", "Shift Begins", "Shift Ends", "Mobile"}
+		cols, isHeader, err := newCSVColumns(dateFields, "date:When,start:Shift Begins,stop:4,target:mobile", first)
+
+		Convey("The mapping should locate the fields", func() {
+			So(err, ShouldBeNil)
+			So(isHeader, ShouldBeTrue)
+			rec, err := cols.row([]string{"5000", "2016-03-05", "09:00", "23:00", "9876"})
+			So(err, ShouldBeNil)
+			So(rec, ShouldResemble, []string{"5000", "2016-03-05", "09:00", "23:00", "9876"})
+		})
+	})
+
+	Convey("Given a column mapping naming an unknown field", t, func() {
+		_, _, err := newCSVColumns(dayFields, "weekday:2", []string{"9998", "M", "09:00", "23:00", "1234"})
+
+		Convey("It should fail", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a column mapping naming a missing header", t, func() {
+		_, _, err := newCSVColumns(dayFields, "target:Pager", []string{"Group", "Day", "Start", "Stop", "Target"})
+
+		Convey("It should fail", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestReadCSV(t *testing.T) {
+	Convey("Given a days CSV with a header, a data row, and a short row", t, func() {
+		file := strings.NewReader("Target,Group,Day,Start,Stop,Notes\n1234,9998,M,09:00,23:00,x\n1235,9998\n")
+		ret := newImportResult(&importOptions{})
+
+		var got [][]string
+		err := readCSV(file, dayFields, &importOptions{}, ret, func(row int, rec []string, guessHeader bool) error {
+			So(guessHeader, ShouldBeFalse)
+			got = append(got, rec)
+			return nil
+		})
+
+		Convey("The data row should be passed in positional order", func() {
+			So(err, ShouldBeNil)
+			So(got, ShouldHaveLength, 1)
+			So(got[0], ShouldResemble, []string{"9998", "M", "09:00", "23:00", "1234"})
+		})
+
+		Convey("The header and the short row should be recorded", func() {
+			So(ret.Rows, ShouldHaveLength, 2)
+			So(ret.Rows[0].Status, ShouldEqual, rowHeader)
+			So(ret.Rows[1].Row, ShouldEqual, 3)
+			So(ret.Rows[1].Status, ShouldEqual, rowError)
+		})
+	})
+}
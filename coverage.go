@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// defaultCoverageSpan is the horizon of a coverage report when no
+// stop time is requested.  One week covers every Day.
+const defaultCoverageSpan = 7 * 24 * time.Hour
+
+// CoverageReport describes how completely a group's Date and Day
+// schedules cover a range of time
+type CoverageReport struct {
+	Group         string     `json:"group"`
+	From          time.Time  `json:"from"`
+	To            time.Time  `json:"to"`
+	DefaultTarget string     `json:"defaultTarget"`
+	Gaps          []Interval `json:"gaps"`     // spans during which no Date or Day is active
+	Overlaps      []Overlap  `json:"overlaps"` // spans during which more than one Date or Day is active
+	Covered       bool       `json:"covered"`  // whether every span resolves to some target
+}
+
+// Overlap is a span of time during which more than one entry of a
+// single layer (date or day) is active
+type Overlap struct {
+	Start time.Time `json:"start"`
+	Stop  time.Time `json:"stop"`
+	Layer string    `json:"layer"` // date or day
+	Keys  []string  `json:"keys"`  // BoltDB keys of the overlapping entries
+}
+
+// getCoverageHandler returns the coverage report for a group over the
+// range given by the optional RFC3339 `from` and `to` parameters
+// (defaulting to now and one week from `from`).
+func getCoverageHandler(ctx *echo.Context) error {
+	from, to, err := parseRange(ctx.Query("from"), ctx.Query("to"), defaultCoverageSpan)
+	if err != nil {
+		return ctx.String(400, err.Error())
+	}
+	if to.Sub(from) > maxTimelineSpan {
+		return ctx.String(400, fmt.Sprintf("Range may not exceed %s", maxTimelineSpan))
+	}
+
+	r, err := getCoverage(storeFromContext(ctx), ctx.Param("id"), from, to)
+	if err != nil {
+		if err == ErrNotFound {
+			return ctx.String(404, "Not found")
+		}
+		return ctx.String(500, err.Error())
+	}
+	return ctx.JSON(200, r)
+}
+
+// getCoverage loads the schedule for the group and analyzes its
+// coverage over the given range
+func getCoverage(s Store, groupID string, from, to time.Time) (*CoverageReport, error) {
+	g, err := s.Group(groupID)
+	if err != nil {
+		return nil, err
+	}
+	return coverageOf(s, g, from, to)
+}
+
+// coverageOf analyzes the coverage of the group's schedule, as
+// held by the given Store, over the given range
+func coverageOf(s Store, g *Group, from, to time.Time) (*CoverageReport, error) {
+	dates, err := s.Dates(g)
+	if err != nil {
+		return nil, err
+	}
+	days, err := s.Days(g)
+	if err != nil {
+		return nil, err
+	}
+
+	if loc, err := g.GetLocation(); err == nil {
+		from = from.In(loc)
+		to = to.In(loc)
+	}
+
+	return analyzeCoverage(g, dates, days, from, to), nil
+}
+
+// analyzeCoverage reports the spans of the given range which are not
+// covered by any Date or Day, as well as the spans during which more
+// than one Date (or more than one Day) is active.  A Date which is
+// active at the same time as a Day is not an overlap; the Date simply
+// takes precedence.
+func analyzeCoverage(g *Group, dates []Date, days []Day, from, to time.Time) *CoverageReport {
+	r := &CoverageReport{
+		Group:         g.ID,
+		From:          from,
+		To:            to,
+		DefaultTarget: g.DefaultTarget,
+		Gaps:          []Interval{},
+		Overlaps:      []Overlap{},
+	}
+
+	dates = datesInRange(dates, from, to)
+	bounds := scheduleBounds(dates, days, from, to)
+
+	addOverlap := func(start, stop time.Time, layer string, keys []string) {
+		if len(keys) < 2 {
+			return
+		}
+		if n := len(r.Overlaps); n > 0 {
+			last := &r.Overlaps[n-1]
+			if last.Layer == layer && last.Stop.Equal(start) && strings.Join(last.Keys, ",") == strings.Join(keys, ",") {
+				last.Stop = stop
+				return
+			}
+		}
+		r.Overlaps = append(r.Overlaps, Overlap{Start: start, Stop: stop, Layer: layer, Keys: keys})
+	}
+
+	for i := 1; i < len(bounds); i++ {
+		start, stop := bounds[i-1].In(from.Location()), bounds[i].In(from.Location())
+		if !stop.After(start) {
+			continue
+		}
+
+		// The active entries are constant between bounds, so sample the middle
+		mid := start.Add(stop.Sub(start) / 2)
+
+		var activeDates, activeDays []string
+		for _, d := range dates {
+			if d.ActiveAt(mid) {
+				activeDates = append(activeDates, string(d.Key()))
+			}
+		}
+		for _, d := range days {
+			if d.ActiveAt(mid) {
+				activeDays = append(activeDays, string(d.Key()))
+			}
+		}
+
+		if len(activeDates) == 0 && len(activeDays) == 0 {
+			if n := len(r.Gaps); n > 0 && r.Gaps[n-1].Stop.Equal(start) {
+				r.Gaps[n-1].Stop = stop
+			} else {
+				r.Gaps = append(r.Gaps, Interval{Start: start, Stop: stop})
+			}
+		}
+		addOverlap(start, stop, layerDate, activeDates)
+		addOverlap(start, stop, layerDay, activeDays)
+	}
+
+	r.Covered = len(r.Gaps) == 0 || g.DefaultTarget != ""
+	return r
+}
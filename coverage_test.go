@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAnalyzeCoverage(t *testing.T) {
+	g := &Group{
+		ID:       "testCoverageGroup",
+		Location: locString,
+	}
+	days := []Day{
+		Day{
+			Group:    g.ID,
+			Target:   "200",
+			Day:      time.Monday,
+			Start:    0,              // 00:00
+			Duration: 12 * time.Hour, // until 12:00
+			Location: locString,
+		},
+		Day{
+			Group:    g.ID,
+			Target:   "201",
+			Day:      time.Monday,
+			Start:    10 * time.Hour, // 10:00
+			Duration: 14 * time.Hour, // until 24:00
+			Location: locString,
+		},
+	}
+
+	Convey("Given a Monday-only Day schedule with an overlap from 10:00 to 12:00", t, func() {
+		from := time.Date(2016, 01, 25, 0, 0, 0, 0, loc) // Monday
+		to := from.Add(2 * 24 * time.Hour)
+		r := analyzeCoverage(g, nil, days, from, to)
+
+		Convey("Tuesday should be reported as a gap", func() {
+			So(r.Gaps, ShouldHaveLength, 1)
+			So(r.Gaps[0].Start.Equal(from.Add(24*time.Hour)), ShouldBeTrue)
+			So(r.Gaps[0].Stop.Equal(to), ShouldBeTrue)
+		})
+
+		Convey("The overlap should be reported with both keys", func() {
+			So(r.Overlaps, ShouldHaveLength, 1)
+			So(r.Overlaps[0].Layer, ShouldEqual, layerDay)
+			So(r.Overlaps[0].Start.Hour(), ShouldEqual, 10)
+			So(r.Overlaps[0].Stop.Hour(), ShouldEqual, 12)
+			So(r.Overlaps[0].Keys, ShouldHaveLength, 2)
+		})
+
+		Convey("The schedule should not be covered", func() {
+			So(r.Covered, ShouldBeFalse)
+		})
+
+		Convey("With a default target, the schedule should be covered", func() {
+			withDefault := &Group{ID: g.ID, Location: locString, DefaultTarget: "100"}
+			r := analyzeCoverage(withDefault, nil, days, from, to)
+			So(r.Gaps, ShouldHaveLength, 1)
+			So(r.Covered, ShouldBeTrue)
+		})
+
+		Convey("A Date covering Tuesday should close the gap", func() {
+			dates := []Date{
+				Date{
+					Group:  g.ID,
+					Target: "300",
+					Date:   from.Add(24 * time.Hour),
+					Time:   24 * time.Hour,
+				},
+			}
+			r := analyzeCoverage(g, dates, days, from, to)
+			So(r.Gaps, ShouldBeEmpty)
+			So(r.Covered, ShouldBeTrue)
+		})
+	})
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"bytes"
 	"encoding/gob"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/boltdb/bolt"
@@ -20,99 +22,171 @@ type Date struct {
 	Time   time.Duration // Duration of event
 }
 
+// dateKeyFormat is the layout of Date keys: the start time, in UTC,
+// at a fixed width, so that keys sort chronologically
+const dateKeyFormat = "2006-01-02T15:04:05.000000000Z"
+
+// dateSpanKey is the key, in the bucket of a group, of the duration
+// of the longest Date of the group, which bounds the range of Dates
+// which may be active at any time
+var dateSpanKey = []byte("dateSpan")
+
 // TimeToDateKey returns the BoltDB "date" bucket key for the
 // given time.
 func TimeToDateKey(t time.Time) []byte {
-	return []byte(t.String())
+	return []byte(t.UTC().Format(dateKeyFormat))
 }
 
 // DateRangeFor returns the BoltDB "date" bucket keys for
 // the start and stop range filters to find Dates which
-// may be applicable for the given time.
-// We return -48hours and current.
-func DateRangeFor(t time.Time) (from, to []byte) {
-	start := t.Add(-48 * time.Hour)
-	return TimeToDateKey(start), TimeToDateKey(t)
+// may be applicable for the given time, where span is the
+// duration of the longest Date.
+func DateRangeFor(t time.Time, span time.Duration) (from, to []byte) {
+	return TimeToDateKey(t.Add(-span - time.Second)), TimeToDateKey(t.Add(time.Second))
 }
 
-// DatesForGroup returns all Dates for the provided group
-func DatesForGroup(db *bolt.DB, g *Group) (ret []Date, err error) {
-	err = db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(g.Key())
-		if b == nil {
-			return errors.New("Group bucket not found")
-		}
-		b = b.Bucket(datesBucket)
-		if b == nil {
-			return errors.New("Dates bucket not found")
-		}
-		c := b.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			var d Date
-			err = decodeDate(v, &d)
-			if err != nil {
-				Log.Error("Failed to decode date", "raw", v, "error", err)
-				continue
-			}
-			ret = append(ret, d)
-		}
+// getDateSpan returns the duration of the longest Date of the group
+// bucket, and false if it is not recorded
+func getDateSpan(b *bolt.Bucket) (time.Duration, bool) {
+	v := b.Get(dateSpanKey)
+	if v == nil {
+		return 0, false
+	}
+	span, err := time.ParseDuration(string(v))
+	if err != nil {
+		return 0, false
+	}
+	return span, true
+}
+
+// extendDateSpan records the duration of the Date in the group
+// bucket, if it is the longest
+func extendDateSpan(b *bolt.Bucket, d time.Duration) error {
+	if span, ok := getDateSpan(b); ok && span >= d {
 		return nil
-	})
-	return
+	}
+	return b.Put(dateSpanKey, []byte(d.String()))
 }
 
-// ActiveDate returns the currently-active Date in the schedule.
-func ActiveDate(db *bolt.DB, g *Group, t time.Time) *Date {
-	var d Date
-	var err error
+// activeDateWithTx returns the first Date of the group which is
+// active at the given time, or nil if there is none.  Only the
+// Dates which start within the span of the longest Date before the
+// time are read.
+func activeDateWithTx(tx *bolt.Tx, g *Group, t time.Time) (*Date, error) {
+	b := tx.Bucket(g.Key())
+	if b == nil {
+		return nil, nil
+	}
+	dates := b.Bucket(datesBucket)
+	if dates == nil {
+		return nil, nil
+	}
+
+	c := dates.Cursor()
+	var k, v []byte
+	span, ok := getDateSpan(b)
+	from, to := DateRangeFor(t, span)
+	if ok {
+		k, v = c.Seek(from)
+	} else {
+		k, v = c.First()
+	}
 
-	err = db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(g.Key())
-		if b == nil {
-			return fmt.Errorf("Group bucket not found")
+	var list []Date
+	for ; k != nil && bytes.Compare(k, to) <= 0; k, v = c.Next() {
+		var d Date
+		if err := decodeDate(v, &d); err != nil {
+			Log.Error("Failed to decode date", "raw", v, "error", err)
+			continue
 		}
-		b = b.Bucket(datesBucket)
-		if b == nil {
-			return fmt.Errorf("Dates bucket not found")
+		list = append(list, d)
+	}
+	return activeDate(list, t), nil
+}
+
+// datesBetweenWithTx returns the Dates of the group which end after
+// from and start before to.  As for activeDateWithTx, only the Dates
+// which start within the span of the longest Date before from are
+// read.
+func datesBetweenWithTx(tx *bolt.Tx, g *Group, from, to time.Time) ([]Date, error) {
+	b := tx.Bucket(g.Key())
+	if b == nil {
+		return nil, nil
+	}
+	dates := b.Bucket(datesBucket)
+	if dates == nil {
+		return nil, nil
+	}
+
+	c := dates.Cursor()
+	var k, v []byte
+	if span, ok := getDateSpan(b); ok && !from.IsZero() {
+		k, v = c.Seek(TimeToDateKey(from.Add(-span - time.Second)))
+	} else {
+		k, v = c.First()
+	}
+
+	var list []Date
+	for ; k != nil; k, v = c.Next() {
+		if !to.IsZero() && bytes.Compare(k, TimeToDateKey(to)) >= 0 {
+			break
 		}
-		c := b.Cursor()
-		Log.Debug("Checking dates for", "group", g.Name, "size", b.Stats().KeyN)
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			Log.Debug("Checking date", "group", g.Name)
-			err = decodeDate(v, &d)
-			if err != nil {
-				Log.Error("Failed to decode date", "raw", v, "error", err)
-				continue
-			}
-			if d.ActiveAt(t) {
-				// Found
-				return nil
-			}
+		var d Date
+		if err := decodeDate(v, &d); err != nil {
+			Log.Error("Failed to decode date", "raw", v, "error", err)
+			continue
 		}
-		return fmt.Errorf("No active date found")
+		list = append(list, d)
+	}
+	return datesBetween(list, from, to), nil
+}
+
+// DatesForGroup returns all Dates for the provided group
+func DatesForGroup(db *bolt.DB, g *Group) (ret []Date, err error) {
+	err = db.View(func(tx *bolt.Tx) error {
+		ret, err = datesForGroupWithTx(tx, g)
+		return err
 	})
-	if err != nil {
-		Log.Info("Date target not found", "error", err, "group", g.Name)
-		return nil
+	return
+}
+
+func datesForGroupWithTx(tx *bolt.Tx, g *Group) (ret []Date, err error) {
+	b := tx.Bucket(g.Key())
+	if b == nil {
+		return nil, errors.New("Group bucket not found")
 	}
-	if d.Target == "" {
-		return nil
+	b = b.Bucket(datesBucket)
+	if b == nil {
+		return nil, errors.New("Dates bucket not found")
+	}
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var d Date
+		err = decodeDate(v, &d)
+		if err != nil {
+			Log.Error("Failed to decode date", "raw", v, "error", err)
+			continue
+		}
+		ret = append(ret, d)
 	}
-	return &d
+	return ret, nil
 }
 
 // Key returns the BoltDB key for this date
 func (d *Date) Key() []byte {
-	return []byte(d.Date.String())
+	return TimeToDateKey(d.Date)
 }
 
 // Save stores the Date in the database
 func (d *Date) Save(tx *bolt.Tx) error {
-	b, err := tx.CreateBucketIfNotExists([]byte(d.Group))
+	g, err := tx.CreateBucketIfNotExists([]byte(d.Group))
 	if err != nil {
 		return err
 	}
-	b, err = b.CreateBucketIfNotExists(datesBucket)
+	if err = extendDateSpan(g, d.Time); err != nil {
+		return err
+	}
+	b, err := g.CreateBucketIfNotExists(datesBucket)
 	if err != nil {
 		return err
 	}
@@ -130,7 +204,13 @@ func (d *Date) ToExternal() *DateExternal {
 	e.Target = d.Target
 	e.Date = d.Date.Format("2006-01-02")
 	e.Start = d.Date.Format("15:04")
-	e.Stop = d.Date.Add(d.Time).Format("15:04")
+	if d.Time < 24*time.Hour {
+		e.Stop = formatTime(timeSinceMidnight(d.Date) + d.Time)
+	} else {
+		// A stop time alone would wrap to the start
+		stop := d.Date.Add(d.Time)
+		e.Stop = stop.Format("2006-01-02 ") + formatTime(timeSinceMidnight(stop))
+	}
 	return &e
 }
 
@@ -140,11 +220,17 @@ func (d *Date) ActiveAt(t time.Time) bool {
 	return t.After(d.Date.Add(-time.Second)) && t.Before(d.Date.Add(d.Time).Add(time.Second))
 }
 
+// Overlaps says whether this Date and the given Date are
+// ever active at the same time.
+func (d *Date) Overlaps(o *Date) bool {
+	return d.Date.Before(o.Date.Add(o.Time)) && o.Date.Before(d.Date.Add(d.Time))
+}
+
 // NewDateFromCSV takes a slice of strings (from a CSV), and
 // parses them into a Unit.
 // Format:
 //	 `groupId`, `date`, `startTime`, `stopTime`, `cell/target`
-func NewDateFromCSV(db *bolt.DB, d []string) (*Date, error) {
+func NewDateFromCSV(s Store, d []string) (*Date, error) {
 	if len(d) != 5 {
 		return nil, fmt.Errorf("CSV not in Group,Date,Time,Cell format")
 	}
@@ -157,7 +243,7 @@ func NewDateFromCSV(db *bolt.DB, d []string) (*Date, error) {
 		Target: d[4],
 	}
 
-	return e.ToDate(db)
+	return e.ToDate(s)
 }
 
 // DateExternal represents a Date schedule unit
@@ -167,11 +253,11 @@ type DateExternal struct {
 	Target string `json:"target"` // Target
 	Date   string `json:"date"`   // Start date of event (YYYY-MM-DD)
 	Start  string `json:"start"`  // Start time (HH:MM)
-	Stop   string `json:"stop"`   // Stop Time  (HH:MM)
+	Stop   string `json:"stop"`   // Stop Time  (HH:MM, or YYYY-MM-DD HH:MM from a day on)
 }
 
 // ToDate converts an exported date schedule to a proper Date schedule
-func (e *DateExternal) ToDate(db *bolt.DB) (*Date, error) {
+func (e *DateExternal) ToDate(s Store) (*Date, error) {
 	var ret Date
 
 	// Short-circuit if we have no target
@@ -184,7 +270,7 @@ func (e *DateExternal) ToDate(db *bolt.DB) (*Date, error) {
 	if ret.Group == "" {
 		return nil, fmt.Errorf("Group is mandatory")
 	}
-	g, err := getGroup(db, e.Group)
+	g, err := s.Group(e.Group)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to find group: %s", err.Error())
 	}
@@ -206,33 +292,83 @@ func (e *DateExternal) ToDate(db *bolt.DB) (*Date, error) {
 		return nil, fmt.Errorf("Failed to parse time range: %s", err.Error())
 	}
 
-	// 3: Stop time
-	stop, err := parseTime(e.Stop)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to parse time range: %s", err.Error())
-	}
+	// 3: Stop time, on the start date (or the next, if it is
+	// earlier), unless it is given with its own date
+	ret.Date = ret.Date.Add(start)
+	if i := strings.IndexByte(e.Stop, ' '); i >= 0 {
+		stopDate, err := parseDate(e.Stop[:i], loc)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse stop date: %s", err.Error())
+		}
+		stop, err := parseTime(strings.TrimSpace(e.Stop[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse time range: %s", err.Error())
+		}
+		ret.Time = stopDate.Add(stop).Sub(ret.Date)
+		if ret.Time <= 0 {
+			return nil, fmt.Errorf("Stop %s is not after the start", e.Stop)
+		}
+	} else {
+		stop, err := parseTime(e.Stop)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse time range: %s", err.Error())
+		}
 
-	diff := stop - start
-	if diff < 0 {
-		// If the time is negative, we wrapped to the next day
-		diff += 24 * time.Hour
+		diff := stop - start
+		if diff < 0 {
+			// If the time is negative, we wrapped to the next day
+			diff += 24 * time.Hour
+		}
+		ret.Time = diff
 	}
 
-	ret.Date = ret.Date.Add(start)
-	ret.Time = diff
-
 	ret.Target = e.Target
 
 	return &ret, nil
 
 }
 
+// dateRecord is the stored encoding of a Date
+type dateRecord struct {
+	V        int       `json:"v"`
+	Group    string    `json:"group"`
+	Target   string    `json:"target"`
+	Start    time.Time `json:"start"`    // RFC3339
+	Duration string    `json:"duration"` // e.g. 8h30m0s
+}
+
 func encodeDate(d *Date) ([]byte, error) {
-	var buf bytes.Buffer
-	err := gob.NewEncoder(&buf).Encode(d)
-	return buf.Bytes(), err
+	return json.Marshal(&dateRecord{
+		V:        recordVersion,
+		Group:    d.Group,
+		Target:   d.Target,
+		Start:    d.Date,
+		Duration: d.Time.String(),
+	})
 }
 
+// decodeDate decodes a stored Date, which may be a legacy
+// gob-encoded record
 func decodeDate(data []byte, d *Date) error {
-	return gob.NewDecoder(bytes.NewReader(data)).Decode(d)
+	if isGobRecord(data) {
+		return gob.NewDecoder(bytes.NewReader(data)).Decode(d)
+	}
+	var r dateRecord
+	if err := json.Unmarshal(data, &r); err != nil {
+		return err
+	}
+	if err := checkRecordVersion(r.V); err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(r.Duration)
+	if err != nil {
+		return err
+	}
+	*d = Date{
+		Group:  r.Group,
+		Target: r.Target,
+		Date:   r.Start,
+		Time:   duration,
+	}
+	return nil
 }
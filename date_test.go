@@ -38,7 +38,7 @@ func TestDateFromExternal(t *testing.T) {
 		Stop:   "23:59",
 	}
 
-	d, err := ext.ToDate(dateDb)
+	d, err := ext.ToDate(newBoltStore(dateDb))
 	if err != nil {
 		t.Error("failed to convert external date", err)
 		return
@@ -61,7 +61,7 @@ func TestNewDateFromCSV(t *testing.T) {
 		row := []string{"testGroup", "Mon", "02:00", "1234"}
 
 		Convey("date conversion should fail", func() {
-			_, err := NewDateFromCSV(dateDb, row)
+			_, err := NewDateFromCSV(newBoltStore(dateDb), row)
 			So(err, ShouldNotBeNil)
 		})
 	})
@@ -70,7 +70,7 @@ func TestNewDateFromCSV(t *testing.T) {
 		row := []string{"testGroup", "2016-02-13", "02:00", "06:00", "1234"}
 
 		Convey("The resulting Day should be congruent", func() {
-			date, err := NewDateFromCSV(dateDb, row)
+			date, err := NewDateFromCSV(newBoltStore(dateDb), row)
 			So(err, ShouldBeNil)
 			So(date.Group, ShouldEqual, "testGroup")
 			So(date.Date.Unix(), ShouldEqual, time.Date(2016, 02, 13, 2, 0, 0, 0, loc).Unix())
@@ -302,3 +302,97 @@ func TestDateDump(t *testing.T) {
 		return
 	}
 }
+
+func TestActiveDateRange(t *testing.T) {
+	g := &Group{ID: "testActiveDateRange", Location: locString}
+	s := newBoltStore(dateDb)
+	saveGroup(dateDb, g)
+
+	Convey("Given Dates in several timezones", t, func() {
+		pacific, _ := time.LoadLocation("US/Pacific")
+		dates := []Date{
+			Date{Target: "300", Date: time.Date(2016, 03, 13, 1, 0, 0, 0, loc), Time: time.Hour},
+			Date{Target: "301", Date: time.Date(2016, 03, 12, 23, 30, 0, 0, pacific), Time: time.Hour},
+			Date{Target: "302", Date: time.Date(2016, 03, 01, 0, 0, 0, 0, loc), Time: 14 * 24 * time.Hour},
+		}
+		So(s.ReplaceDates(g, dates), ShouldBeNil)
+
+		Convey("Their keys should sort chronologically", func() {
+			list, err := s.Dates(g)
+			So(err, ShouldBeNil)
+			So(list, ShouldHaveLength, 3)
+			So(list[0].Target, ShouldEqual, "302")
+			So(list[1].Target, ShouldEqual, "300")
+			So(list[2].Target, ShouldEqual, "301")
+		})
+
+		Convey("The active Date should be found within the span of the longest Date", func() {
+			d, err := s.ActiveDate(g, time.Date(2016, 03, 13, 1, 30, 0, 0, loc))
+			So(err, ShouldBeNil)
+			So(d.Target, ShouldEqual, "302")
+
+			d, err = s.ActiveDate(g, time.Date(2016, 03, 13, 3, 0, 0, 0, loc))
+			So(err, ShouldBeNil)
+			So(d.Target, ShouldEqual, "302")
+
+			d, err = s.ActiveDate(g, time.Date(2016, 03, 15, 2, 0, 0, 0, loc))
+			So(err, ShouldBeNil)
+			So(d, ShouldBeNil)
+		})
+
+		Convey("Replacing the Dates should reset the span", func() {
+			So(s.ReplaceDates(g, dates[:2]), ShouldBeNil)
+			dateDb.View(func(tx *bolt.Tx) error {
+				span, ok := getDateSpan(tx.Bucket(g.Key()))
+				So(ok, ShouldBeTrue)
+				So(span, ShouldEqual, time.Hour)
+				return nil
+			})
+
+			d, err := s.ActiveDate(g, time.Date(2016, 03, 13, 3, 45, 0, 0, loc))
+			So(err, ShouldBeNil)
+			So(d.Target, ShouldEqual, "301")
+		})
+	})
+}
+
+// benchmarkDates gives the group a Date on every day of the given
+// number of years
+func benchmarkDates(b *testing.B, g *Group, years int) {
+	saveGroup(dateDb, g)
+	var dates []Date
+	start := time.Date(2016, 01, 01, 9, 0, 0, 0, loc)
+	for t := start; t.Before(start.AddDate(years, 0, 0)); t = t.AddDate(0, 0, 1) {
+		dates = append(dates, Date{Target: "300", Date: t, Time: 8 * time.Hour})
+	}
+	if err := newBoltStore(dateDb).ReplaceDates(g, dates); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+}
+
+func BenchmarkActiveDate(b *testing.B) {
+	g := &Group{ID: "benchmarkActiveDate", Location: locString}
+	benchmarkDates(b, g, 5)
+	s := newBoltStore(dateDb)
+	at := time.Date(2018, 06, 15, 12, 0, 0, 0, loc)
+	for i := 0; i < b.N; i++ {
+		if d, _ := s.ActiveDate(g, at); d == nil {
+			b.Fatal("no active date")
+		}
+	}
+}
+
+// BenchmarkActiveDateScan decodes every Date of the group, as
+// ActiveDate did before Dates were keyed by UTC start time
+func BenchmarkActiveDateScan(b *testing.B) {
+	g := &Group{ID: "benchmarkActiveDateScan", Location: locString}
+	benchmarkDates(b, g, 5)
+	at := time.Date(2018, 06, 15, 12, 0, 0, 0, loc)
+	for i := 0; i < b.N; i++ {
+		dates, _ := DatesForGroup(dateDb, g)
+		if activeDate(dates, at) == nil {
+			b.Fatal("no active date")
+		}
+	}
+}
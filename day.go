@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"encoding/gob"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
@@ -40,7 +41,7 @@ func (d *Day) GetLocation() (*time.Location, error) {
 // TimeToDayKey returns the BoltDB "day" bucket key for the current time
 func TimeToDayKey(t time.Time) []byte {
 	minutes := t.Hour()*60 + t.Minute()
-	return []byte(fmt.Sprintf("%d:%02.f", t.Day(), float64(minutes)))
+	return []byte(fmt.Sprintf("%d:%04d", t.Weekday(), minutes))
 }
 
 // DayRangeFor returns the BoltDB "day" bucket keys for
@@ -55,90 +56,50 @@ func DayRangeFor(t time.Time) (from, to []byte) {
 // DaysForGroup returns all Days for the provided group
 func DaysForGroup(db *bolt.DB, g *Group) (ret []Day, err error) {
 	err = db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(g.Key())
-		if b == nil {
-			return errors.New("Group bucket not found")
-		}
-		b = b.Bucket(daysBucket)
-		if b == nil {
-			return errors.New("Days bucket not found")
-		}
-		c := b.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			var d Day
-			err = decodeDay(v, &d)
-			if err != nil {
-				Log.Error("Failed to decode day", "raw", v, "error", err)
-				continue
-			}
-			ret = append(ret, d)
-		}
-		return nil
+		ret, err = daysForGroupWithTx(tx, g)
+		return err
 	})
 	return
 }
 
-// ActiveDay returns the currently-active Day in the
-// schedule.
-func ActiveDay(db *bolt.DB, g *Group, t time.Time) *Day {
-	var err error
-	var d Day
-
-	if g == nil {
-		Log.Error("No group supplied")
-		return nil
+func daysForGroupWithTx(tx *bolt.Tx, g *Group) (ret []Day, err error) {
+	b := tx.Bucket(g.Key())
+	if b == nil {
+		return nil, errors.New("Group bucket not found")
 	}
-
-	// Walk through the database until we find the first
-	// active Day
-	err = db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(g.Key())
-		if b == nil {
-			return err
-		}
-		b = b.Bucket(daysBucket)
-		if b == nil {
-			return err
-		}
-		c := b.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			err = decodeDay(v, &d)
-			if err != nil {
-				fmt.Println("Failed to decode day", v, err)
-				continue
-			}
-
-			if d.ActiveAt(t) {
-				Log.Info("Day is active", "day", d)
-				return nil
-			}
-		}
-		return fmt.Errorf("No active day found")
-	})
-	if err != nil {
-		Log.Error("No match", "error", err)
-		return nil
+	b = b.Bucket(daysBucket)
+	if b == nil {
+		return nil, errors.New("Days bucket not found")
 	}
-	if d.Target == "" {
-		return nil
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var d Day
+		err = decodeDay(v, &d)
+		if err != nil {
+			Log.Error("Failed to decode day", "raw", v, "error", err)
+			continue
+		}
+		ret = append(ret, d)
 	}
-	return &d
+	return ret, nil
 }
 
-// Key returns the BoltDB key for this day
+// Key returns the BoltDB key for this day.  The minutes are
+// zero-padded, so that keys sort in order of start time.
 func (d *Day) Key() []byte {
-	return []byte(fmt.Sprintf("%d:%02.f", d.Day, d.Start.Minutes()))
+	return []byte(fmt.Sprintf("%d:%04.f", d.Day, d.Start.Minutes()))
 }
 
-// ToExternal exports a Day schedule to its external version
-func (d *Day) ToExternal(now time.Time) *DayExternal {
+// ToExternal exports a Day schedule to its external version.
+// The times are wall-clock times, independent of any DST
+// transition, such that ToDay restores the same Day.
+func (d *Day) ToExternal() *DayExternal {
 	var e DayExternal
-	start, stop := d.Times(now)
 	e.Group = d.Group
 	e.Target = d.Target
 	e.Day = d.Day.String()
-	e.Start = start.Format("15:04")
-	e.Stop = stop.Format("15:04")
+	e.Start = formatTime(d.Start)
+	e.Stop = formatTime(d.Start + d.Duration)
 	return &e
 }
 
@@ -165,7 +126,7 @@ func (d *Day) Times(now time.Time) (closestStart time.Time, closestStop time.Tim
 		// the most recent day that matches the day
 		// of the week of our Day.
 		for i := 1; i < 8; i++ {
-			sTime := now.Add(-time.Duration(i) * 24 * time.Hour)
+			sTime := now.AddDate(0, 0, -i)
 			if sTime.Weekday() == d.Day {
 				closestStart = todayAt(sTime, d.Start)
 				break
@@ -180,6 +141,53 @@ func (d *Day) Times(now time.Time) (closestStart time.Time, closestStop time.Tim
 	return
 }
 
+// Instances returns the start and stop times of every
+// instance of this Day which overlaps the given range.
+func (d *Day) Instances(from, to time.Time) (ret []Interval) {
+	if loc, err := d.GetLocation(); loc != nil && err == nil {
+		from = from.In(loc)
+	}
+
+	// Start a day early to catch shifts which began
+	// before the range and run into it
+	for cur := timeOfLastMidnight(from).AddDate(0, 0, -1); cur.Before(to); cur = cur.AddDate(0, 0, 1) {
+		if cur.Weekday() != d.Day {
+			continue
+		}
+		start := todayAt(cur, d.Start)
+		stop := start.Add(d.Duration)
+		if stop.After(from) && start.Before(to) {
+			ret = append(ret, Interval{Start: start, Stop: stop})
+		}
+	}
+	return
+}
+
+// weekLength is the period of a Day schedule
+const weekLength = 7 * 24 * time.Hour
+
+// weekSpan returns the start and stop of this Day as offsets from
+// the start of the week (Sunday, 00:00)
+func (d *Day) weekSpan() (start, stop time.Duration) {
+	start = time.Duration(d.Day)*24*time.Hour + d.Start
+	return start, start + d.Duration
+}
+
+// Overlaps says whether this Day and the given Day are
+// ever active at the same time.
+func (d *Day) Overlaps(o *Day) bool {
+	s1, e1 := d.weekSpan()
+	s2, e2 := o.weekSpan()
+
+	// Shifts which run past the end of the week wrap around
+	for _, shift := range []time.Duration{-weekLength, 0, weekLength} {
+		if s1 < e2+shift && s2+shift < e1 {
+			return true
+		}
+	}
+	return false
+}
+
 // Save stores the Day in the database
 func (d *Day) Save(tx *bolt.Tx) error {
 	b, err := tx.CreateBucketIfNotExists([]byte(d.Group))
@@ -207,17 +215,20 @@ func timeSinceMidnight(t time.Time) time.Duration {
 }
 
 // timeOfLastMidnight returns the time of the most
-// recent midnight
+// recent midnight.  It uses calendar arithmetic, so
+// it remains correct across DST transitions.
 func timeOfLastMidnight(t time.Time) time.Time {
-	t = t.Truncate(time.Minute)
-	return t.Add(-timeSinceMidnight(t))
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
 }
 
-// todayAt returns the time for today
-// at the given difference in time from midnight.
+// todayAt returns the time for today at the given
+// difference in (wall-clock) time from midnight.
+// On DST transition days, a schedule for 09:00 still
+// begins at 09:00 local time.
 func todayAt(t time.Time, diff time.Duration) time.Time {
-	base := timeOfLastMidnight(t)
-	return base.Add(diff)
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, int(diff/time.Second), 0, t.Location())
 }
 
 // ActiveAt says whether the given time is
@@ -308,12 +319,61 @@ func (e *DayExternal) ToDay() (*Day, error) {
 	return &ret, nil
 }
 
+// dayRecord is the stored encoding of a Day
+type dayRecord struct {
+	V        int    `json:"v"`
+	Group    string `json:"group"`
+	Target   string `json:"target"`
+	Day      string `json:"day"`      // e.g. Monday
+	Start    string `json:"start"`    // e.g. 9h0m0s
+	Duration string `json:"duration"` // e.g. 8h30m0s
+	Location string `json:"location"`
+}
+
 func encodeDay(d *Day) ([]byte, error) {
-	var buf bytes.Buffer
-	err := gob.NewEncoder(&buf).Encode(d)
-	return buf.Bytes(), err
+	return json.Marshal(&dayRecord{
+		V:        recordVersion,
+		Group:    d.Group,
+		Target:   d.Target,
+		Day:      d.Day.String(),
+		Start:    d.Start.String(),
+		Duration: d.Duration.String(),
+		Location: d.Location,
+	})
 }
 
+// decodeDay decodes a stored Day, which may be a legacy
+// gob-encoded record
 func decodeDay(data []byte, d *Day) error {
-	return gob.NewDecoder(bytes.NewReader(data)).Decode(d)
+	if isGobRecord(data) {
+		return gob.NewDecoder(bytes.NewReader(data)).Decode(d)
+	}
+	var r dayRecord
+	if err := json.Unmarshal(data, &r); err != nil {
+		return err
+	}
+	if err := checkRecordVersion(r.V); err != nil {
+		return err
+	}
+	day, err := parseDay(r.Day)
+	if err != nil {
+		return err
+	}
+	start, err := time.ParseDuration(r.Start)
+	if err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(r.Duration)
+	if err != nil {
+		return err
+	}
+	*d = Day{
+		Group:    r.Group,
+		Target:   r.Target,
+		Day:      day,
+		Start:    start,
+		Duration: duration,
+		Location: r.Location,
+	}
+	return nil
 }
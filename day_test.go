@@ -116,6 +116,47 @@ func TestTodayAt(t *testing.T) {
 	})
 }
 
+func TestTodayAtDST(t *testing.T) {
+	Convey("Given a time (local) on the day DST begins", t, func() {
+		tm := time.Date(2016, 03, 13, 11, 25, 12, 0, loc)
+
+		Convey("The time of the last midnight should be 00:00 that day", func() {
+			mid := timeOfLastMidnight(tm)
+			So(mid.Day(), ShouldEqual, 13)
+			So(mid.Hour(), ShouldEqual, 0)
+		})
+
+		Convey("The time at an offset of 9 hours should be 09:00 local time", func() {
+			nt := todayAt(tm, 9*time.Hour)
+			So(nt.Day(), ShouldEqual, 13)
+			So(nt.Hour(), ShouldEqual, 9)
+			So(nt.Minute(), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestDayInstances(t *testing.T) {
+	Convey("Given a Day of Sunday with start time 22:00 and duration 04:00", t, func() {
+		day := Day{
+			Group:    "0",
+			Target:   "411",
+			Day:      time.Sunday,
+			Start:    22 * time.Hour, // 22:00
+			Duration: 4 * time.Hour,  // until 02:00
+			Location: loc.String(),
+		}
+
+		Convey("The instances over two weeks starting Monday at 00:00 should include the prior Sunday", func() {
+			from := time.Date(2016, 03, 7, 0, 0, 0, 0, loc)
+			list := day.Instances(from, from.Add(14*24*time.Hour))
+			So(list, ShouldHaveLength, 3)
+			So(list[0].Start.Day(), ShouldEqual, 6)
+			So(list[1].Start.Hour(), ShouldEqual, 22)
+			So(list[1].Start.Day(), ShouldEqual, 13)
+		})
+	})
+}
+
 func TestDayTimes(t *testing.T) {
 	Convey("Given a Day", t, func() {
 		day := Day{
@@ -188,6 +229,32 @@ func TestDayActiveAt(t *testing.T) {
 	})
 }
 
+func TestDayOverlapsDay(t *testing.T) {
+	Convey("Given a Day of Saturday with start time 22:00 and duration 04:00", t, func() {
+		day := Day{
+			Day:      time.Saturday,
+			Start:    22 * time.Hour, // 22:00
+			Duration: 4 * time.Hour,  // until Sunday 02:00
+		}
+
+		Convey("It should overlap a Day of Sunday at 01:00, across the end of the week", func() {
+			other := Day{Day: time.Sunday, Start: time.Hour, Duration: time.Hour}
+			So(day.Overlaps(&other), ShouldBeTrue)
+			So(other.Overlaps(&day), ShouldBeTrue)
+		})
+
+		Convey("It should not overlap a Day of Sunday at 02:00", func() {
+			other := Day{Day: time.Sunday, Start: 2 * time.Hour, Duration: time.Hour}
+			So(day.Overlaps(&other), ShouldBeFalse)
+		})
+
+		Convey("It should not overlap a Day of Saturday at 20:00 for two hours", func() {
+			other := Day{Day: time.Saturday, Start: 20 * time.Hour, Duration: 2 * time.Hour}
+			So(day.Overlaps(&other), ShouldBeFalse)
+		})
+	})
+}
+
 func TestDayToExternal(t *testing.T) {
 	Convey("Given a Day with start time 02:00 and duration 04:00", t, func() {
 		day := Day{
@@ -200,7 +267,7 @@ func TestDayToExternal(t *testing.T) {
 		}
 
 		Convey("When the day is exported", func() {
-			e := day.ToExternal(time.Now())
+			e := day.ToExternal()
 
 			Convey("The group should be the same", func() {
 				So(e.Group, ShouldEqual, day.Group)
@@ -341,6 +408,34 @@ func TestParseTime(t *testing.T) {
 	})
 }
 
+func TestParseAt(t *testing.T) {
+	Convey("Given an empty string", t, func() {
+		Convey("The parsed time should be now", func() {
+			at, err := parseAt("")
+			So(err, ShouldBeNil)
+			So(at, ShouldHappenWithin, time.Second, time.Now())
+		})
+	})
+	Convey("Given 2016-01-23T02:00:00-05:00", t, func() {
+		ref := "2016-01-23T02:00:00-05:00"
+
+		Convey("The parsed time should be 02:00 EST on Saturday", func() {
+			at, err := parseAt(ref)
+			So(err, ShouldBeNil)
+			So(at.Unix(), ShouldEqual, time.Date(2016, 01, 23, 2, 0, 0, 0, loc).Unix())
+			So(at.In(loc).Weekday(), ShouldEqual, time.Saturday)
+		})
+	})
+	Convey("Given 2016-01-23 02:00", t, func() {
+		ref := "2016-01-23 02:00"
+
+		Convey("The time should fail to be parsed", func() {
+			_, err := parseAt(ref)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
 func TestNewDayFromCSV(t *testing.T) {
 	Convey("Given a CSV row with too few columns", t, func() {
 		row := []string{"testGroup", "Mon", "02:00", "1234"}
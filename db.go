@@ -1,7 +1,9 @@
 package main
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"os"
 	"path"
 
@@ -24,21 +26,43 @@ func dbOpen(f string) (handle *bolt.DB, err error) {
 		return
 	}
 
-	// Make sure the buckets exist
-	handle.Update(func(tx *bolt.Tx) error {
-		if _, err = tx.CreateBucketIfNotExists(groupBucket); err != nil {
-			return err
-		}
-		if _, err = tx.CreateBucketIfNotExists(daysBucket); err != nil {
-			return err
-		}
-		if _, err = tx.CreateBucketIfNotExists(datesBucket); err != nil {
+	// Bring the schema up to date
+	if err = migrate(handle); err != nil {
+		handle.Close()
+		handle = nil
+	}
+
+	return
+}
+
+// createBuckets creates the top-level buckets of the database,
+// if they do not exist
+func createBuckets(tx *bolt.Tx) error {
+	for _, name := range [][]byte{groupBucket, daysBucket, datesBucket} {
+		if _, err := tx.CreateBucketIfNotExists(name); err != nil {
 			return err
 		}
-		return nil
-	})
+	}
+	return nil
+}
 
-	return
+// recordVersion is the version of the JSON encoding of stored
+// Groups, Days, and Dates
+const recordVersion = 1
+
+// isGobRecord says whether the stored record was encoded with
+// encoding/gob, as records were before they were encoded as JSON
+func isGobRecord(data []byte) bool {
+	return !bytes.HasPrefix(data, []byte(`{"`))
+}
+
+// checkRecordVersion returns an error if a stored record is of a
+// newer version than this version of ipc-schedule can decode
+func checkRecordVersion(version int) error {
+	if version < 1 || version > recordVersion {
+		return fmt.Errorf("Unsupported record version %d", version)
+	}
+	return nil
 }
 
 // dbFromContext returns the database pointer from
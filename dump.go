@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// dumpVersion is the version of the DatabaseDump format.  It must
+// be incremented whenever the format changes incompatibly.
+const dumpVersion = 1
+
+// DatabaseDump is a portable, human-readable dump of every group and
+// its schedule.  Unlike the database itself, it does not depend upon
+// the encoding of Group, Day, or Date.
+type DatabaseDump struct {
+	// Version is the version of the dump format
+	Version int `json:"version"`
+
+	// Exported is the time at which the dump was taken
+	Exported time.Time `json:"exported"`
+
+	// Groups holds each group, along with its days and dates
+	Groups []*GroupDump `json:"groups"`
+}
+
+// GroupDump is a group, along with its schedule, in a DatabaseDump
+type GroupDump struct {
+	ID            string     `json:"id"`
+	Name          string     `json:"name"`
+	Timezone      string     `json:"timezone"`
+	DefaultTarget string     `json:"defaultTarget,omitempty"`
+	Days          []DayDump  `json:"days"`
+	Dates         []DateDump `json:"dates"`
+}
+
+// DayDump is a Day in a DatabaseDump
+type DayDump struct {
+	Day      string `json:"day"`      // Day of the week, e.g. Monday
+	Start    string `json:"start"`    // Start time (HH:MM), in the group's time zone
+	Duration string `json:"duration"` // Length of shift, e.g. 8h30m0s
+	Target   string `json:"target"`
+}
+
+// DateDump is a Date in a DatabaseDump
+type DateDump struct {
+	Start    time.Time `json:"start"`    // Start timestamp (RFC3339)
+	Duration string    `json:"duration"` // Length of event, e.g. 8h30m0s
+	Target   string    `json:"target"`
+}
+
+// dumpHandler prints a DatabaseDump of the entire database
+func dumpHandler(ctx *echo.Context) error {
+	dump, err := dumpDatabase(storeFromContext(ctx), time.Now())
+	if err != nil {
+		return ctx.String(500, err.Error())
+	}
+	data, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		return ctx.String(500, err.Error())
+	}
+
+	name := fmt.Sprintf("ipc-%s.json", dump.Exported.UTC().Format("20060102T150405Z"))
+	h := ctx.Response().Header()
+	h.Set("Content-Type", "application/json; charset=utf-8")
+	h.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	ctx.Response().WriteHeader(200)
+	_, err = ctx.Response().Write(data)
+	return err
+}
+
+// dumpDatabase returns a DatabaseDump of every group of the Store.
+// Days are ordered by day of the week and start time, and dates by
+// start time.
+func dumpDatabase(s Store, now time.Time) (*DatabaseDump, error) {
+	groups, err := s.Groups()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &DatabaseDump{
+		Version:  dumpVersion,
+		Exported: now,
+		Groups:   []*GroupDump{},
+	}
+	for _, g := range groups {
+		gd := &GroupDump{
+			ID:            g.ID,
+			Name:          g.Name,
+			Timezone:      g.Location,
+			DefaultTarget: g.DefaultTarget,
+			Days:          []DayDump{},
+			Dates:         []DateDump{},
+		}
+
+		days, err := s.Days(g)
+		if err != nil {
+			return nil, err
+		}
+		sort.Sort(byWeekTime(days))
+		for _, d := range days {
+			gd.Days = append(gd.Days, DayDump{
+				Day:      d.Day.String(),
+				Start:    formatTime(d.Start),
+				Duration: d.Duration.String(),
+				Target:   d.Target,
+			})
+		}
+
+		dates, err := s.Dates(g)
+		if err != nil {
+			return nil, err
+		}
+		sort.Sort(byStart(dates))
+		for _, d := range dates {
+			gd.Dates = append(gd.Dates, DateDump{
+				Start:    d.Date,
+				Duration: d.Time.String(),
+				Target:   d.Target,
+			})
+		}
+
+		ret.Groups = append(ret.Groups, gd)
+	}
+	return ret, nil
+}
+
+// readDump decodes a DatabaseDump, checking its version
+func readDump(r io.Reader) (*DatabaseDump, error) {
+	var dump DatabaseDump
+	if err := json.NewDecoder(r).Decode(&dump); err != nil {
+		return nil, fmt.Errorf("Failed to parse dump: %s", err.Error())
+	}
+	if dump.Version < 1 || dump.Version > dumpVersion {
+		return nil, fmt.Errorf("Unsupported dump version %d (expected %d)", dump.Version, dumpVersion)
+	}
+	return &dump, nil
+}
+
+// schedule converts the dumped group to a ScheduleDump, validating
+// each of its days and dates
+func (gd *GroupDump) schedule() (*ScheduleDump, error) {
+	if gd.ID == "" {
+		return nil, fmt.Errorf("Group has no id")
+	}
+	g := &Group{
+		ID:            gd.ID,
+		Name:          gd.Name,
+		Location:      gd.Timezone,
+		DefaultTarget: gd.DefaultTarget,
+	}
+	loc, err := g.GetLocation()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid time zone %s for group %s", g.Location, g.ID)
+	}
+
+	ret := &ScheduleDump{Group: g}
+	for i, dd := range gd.Days {
+		d := Day{Group: g.ID, Target: dd.Target, Location: g.Location}
+		if d.Day, err = parseDay(dd.Day); err != nil {
+			return nil, fmt.Errorf("Day %d of group %s: %s", i+1, g.ID, err.Error())
+		}
+		if d.Start, err = parseTime(dd.Start); err != nil {
+			return nil, fmt.Errorf("Day %d of group %s: %s", i+1, g.ID, err.Error())
+		}
+		if d.Duration, err = time.ParseDuration(dd.Duration); err != nil {
+			return nil, fmt.Errorf("Day %d of group %s: %s", i+1, g.ID, err.Error())
+		}
+		ret.Days = append(ret.Days, d)
+	}
+	for i, dd := range gd.Dates {
+		d := Date{Group: g.ID, Target: dd.Target, Date: dd.Start.In(loc)}
+		if dd.Start.IsZero() {
+			return nil, fmt.Errorf("Date %d of group %s has no start", i+1, g.ID)
+		}
+		if d.Time, err = time.ParseDuration(dd.Duration); err != nil {
+			return nil, fmt.Errorf("Date %d of group %s: %s", i+1, g.ID, err.Error())
+		}
+		ret.Dates = append(ret.Dates, d)
+	}
+	return ret, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDatabaseDump(t *testing.T) {
+	src, err := dbOpen("./dumpTest.db")
+	if err != nil {
+		panic("Failed to open test database")
+	}
+	dst, err := dbOpen("./loadTest.db")
+	if err != nil {
+		panic("Failed to open test database")
+	}
+	defer func() {
+		src.Close()
+		dst.Close()
+		os.Remove("./dumpTest.db")
+		os.Remove("./loadTest.db")
+	}()
+
+	g := Group{ID: "testDumpGroup", Name: "Dump Group", Location: locString, DefaultTarget: "100"}
+	saveGroup(src, &g)
+	saveGroup(src, &Group{ID: "testEmptyGroup", Location: locString})
+	src.Update(func(tx *bolt.Tx) error {
+		days := []Day{
+			Day{Group: g.ID, Target: "201", Day: time.Monday, Start: 9 * time.Hour, Duration: 8*time.Hour + 30*time.Minute, Location: g.Location},
+			Day{Group: g.ID, Target: "200", Day: time.Saturday, Start: 22 * time.Hour, Duration: 4 * time.Hour, Location: g.Location},
+		}
+		for i := range days {
+			if err := days[i].Save(tx); err != nil {
+				return err
+			}
+		}
+		date := Date{Group: g.ID, Target: "300", Date: time.Date(2016, 03, 13, 1, 0, 0, 0, loc), Time: 4 * time.Hour}
+		return date.Save(tx)
+	})
+
+	now := time.Date(2016, 03, 01, 0, 0, 0, 0, time.UTC)
+	dump := func(db *bolt.DB) *DatabaseDump {
+		ret, err := dumpDatabase(newBoltStore(db), now)
+		So(err, ShouldBeNil)
+		return ret
+	}
+
+	Convey("Given a dump of a database", t, func() {
+		d := dump(src)
+
+		Convey("It should be versioned and human-readable", func() {
+			So(d.Version, ShouldEqual, dumpVersion)
+			So(d.Groups, ShouldHaveLength, 2)
+			So(d.Groups[0].ID, ShouldEqual, "testDumpGroup")
+			So(d.Groups[0].Days, ShouldResemble, []DayDump{
+				DayDump{Day: "Monday", Start: "09:00", Duration: "8h30m0s", Target: "201"},
+				DayDump{Day: "Saturday", Start: "22:00", Duration: "4h0m0s", Target: "200"},
+			})
+			So(d.Groups[0].Dates, ShouldHaveLength, 1)
+			So(d.Groups[0].Dates[0].Start.Format(time.RFC3339), ShouldEqual, "2016-03-13T01:00:00-05:00")
+			So(d.Groups[0].Dates[0].Duration, ShouldEqual, "4h0m0s")
+			So(d.Groups[1].Days, ShouldBeEmpty)
+		})
+
+		Convey("Loading it as JSON into another database should recreate it", func() {
+			data, err := json.Marshal(d)
+			So(err, ShouldBeNil)
+			loaded, err := readDump(bytes.NewReader(data))
+			So(err, ShouldBeNil)
+
+			ret, err := restoreDump(newBoltStore(dst), loaded)
+			So(err, ShouldBeNil)
+			So(ret.Groups, ShouldEqual, 2)
+			So(ret.Days, ShouldEqual, 2)
+			So(ret.Dates, ShouldEqual, 1)
+
+			again := dump(dst)
+			for i := range again.Groups {
+				for j := range again.Groups[i].Dates {
+					So(again.Groups[i].Dates[j].Start.Equal(d.Groups[i].Dates[j].Start), ShouldBeTrue)
+					again.Groups[i].Dates[j].Start = d.Groups[i].Dates[j].Start
+				}
+			}
+			So(again, ShouldResemble, d)
+			So(getTarget(newBoltStore(dst), g.ID, time.Date(2016, 03, 13, 2, 0, 0, 0, loc)), ShouldEqual, "300")
+		})
+	})
+
+	Convey("Dumps of an unknown version should be rejected", t, func() {
+		_, err := readDump(strings.NewReader(`{"groups": []}`))
+		So(err, ShouldNotBeNil)
+		_, err = readDump(strings.NewReader(`{"version": 99, "groups": []}`))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Dumps with invalid entries should be rejected", t, func() {
+		gd := &GroupDump{ID: "a", Timezone: locString, Days: []DayDump{DayDump{Day: "Caturday", Start: "01:00", Duration: "1h", Target: "200"}}}
+		_, err := gd.schedule()
+		So(err, ShouldNotBeNil)
+
+		gd = &GroupDump{ID: "a", Timezone: locString, Dates: []DateDump{DateDump{Start: now, Duration: "forever", Target: "200"}}}
+		_, err = gd.schedule()
+		So(err, ShouldNotBeNil)
+	})
+}
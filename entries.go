@@ -0,0 +1,393 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// DayEntry is a Day of a group, in its external form, along with
+// its key, by which it is addressed at /group/:id/days/:key
+type DayEntry struct {
+	Key string `json:"key"`
+	DayExternal
+}
+
+// newDayEntry returns the DayEntry of the Day
+func newDayEntry(d *Day) *DayEntry {
+	return &DayEntry{Key: string(d.Key()), DayExternal: *d.ToExternal()}
+}
+
+// DateEntry is a Date of a group, in its external form, along with
+// its key, by which it is addressed at /group/:id/dates/:key
+type DateEntry struct {
+	Key string `json:"key"`
+	DateExternal
+}
+
+// newDateEntry returns the DateEntry of the Date
+func newDateEntry(d *Date) *DateEntry {
+	return &DateEntry{Key: string(d.Key()), DateExternal: *d.ToExternal()}
+}
+
+// invalidEntryError indicates that a schedule entry is not valid,
+// and nothing was saved
+type invalidEntryError struct {
+	error
+}
+
+// entryConflictError indicates that a schedule entry would replace,
+// or overlap, other entries of the schedule, and nothing was saved
+type entryConflictError struct {
+	Message   string        `json:"error"`
+	Conflicts []interface{} `json:"conflicts"` // the conflicting entries (DayEntry or DateEntry)
+}
+
+func (e *entryConflictError) Error() string {
+	return e.Message
+}
+
+// parseOverlapMode parses the `mode` query parameter of a schedule
+// entry request, returning whether the entry may overlap others
+func parseOverlapMode(ctx *echo.Context) (bool, error) {
+	switch m := strings.ToLower(ctx.Query("mode")); m {
+	case "", overlapStrict:
+		return false, nil
+	case overlapWarn:
+		return true, nil
+	default:
+		return false, fmt.Errorf("Unknown mode %s", m)
+	}
+}
+
+// entryResponse responds to a schedule entry request with v (or,
+// if v is nil, no content), or with the status for the error
+func entryResponse(ctx *echo.Context, code int, v interface{}, err error) error {
+	switch e := err.(type) {
+	case nil:
+		if v == nil {
+			return ctx.NoContent(code)
+		}
+		return ctx.JSON(code, v)
+	case *entryConflictError:
+		return ctx.JSON(409, e)
+	case invalidEntryError:
+		return ctx.String(400, e.Error())
+	}
+	if err == ErrNotFound {
+		return ctx.String(404, "Not found")
+	}
+	return ctx.String(500, err.Error())
+}
+
+func getDaysHandler(ctx *echo.Context) error {
+	list, err := dayEntries(storeFromContext(ctx), ctx.Param("id"))
+	return entryResponse(ctx, 200, list, err)
+}
+
+func getDayHandler(ctx *echo.Context) error {
+	list, err := dayEntries(storeFromContext(ctx), ctx.Param("id"))
+	if err == nil {
+		for _, e := range list {
+			if e.Key == ctx.Param("key") {
+				return ctx.JSON(200, e)
+			}
+		}
+		err = ErrNotFound
+	}
+	return entryResponse(ctx, 200, nil, err)
+}
+
+// postDayHandler adds the Day given as DayExternal JSON to the
+// group.  A Day which overlaps another is refused unless `mode=warn`.
+func postDayHandler(ctx *echo.Context) error {
+	return putDay(ctx, "", 201)
+}
+
+// putDayHandler replaces the Day with the given key with the Day
+// given as DayExternal JSON, whose key may differ
+func putDayHandler(ctx *echo.Context) error {
+	return putDay(ctx, ctx.Param("key"), 200)
+}
+
+func putDay(ctx *echo.Context, replaces string, code int) error {
+	allowOverlap, err := parseOverlapMode(ctx)
+	if err != nil {
+		return ctx.String(400, err.Error())
+	}
+	var e DayExternal
+	if err = ctx.Bind(&e); err != nil {
+		return ctx.String(400, fmt.Sprintf("Failed to parse day: %s", err.Error()))
+	}
+	ret, err := saveDay(storeFromContext(ctx), ctx.Param("id"), replaces, &e, allowOverlap)
+	return entryResponse(ctx, code, ret, err)
+}
+
+func deleteDayHandler(ctx *echo.Context) error {
+	err := deleteDay(storeFromContext(ctx), ctx.Param("id"), ctx.Param("key"))
+	return entryResponse(ctx, 204, nil, err)
+}
+
+// dayEntries returns each Day of the group as a DayEntry
+func dayEntries(s Store, groupID string) ([]*DayEntry, error) {
+	g, err := s.Group(groupID)
+	if err != nil {
+		return nil, err
+	}
+	days, err := s.Days(g)
+	if err != nil {
+		return nil, err
+	}
+	list := []*DayEntry{}
+	for i := range days {
+		list = append(list, newDayEntry(&days[i]))
+	}
+	return list, nil
+}
+
+// dayFromExternal validates the external Day of the group,
+// returning the Day, in the location of the group
+func dayFromExternal(g *Group, e *DayExternal) (*Day, error) {
+	if e.Group != "" && e.Group != g.ID {
+		return nil, invalidEntryError{fmt.Errorf("Day is for group %s, not %s", e.Group, g.ID)}
+	}
+	e.Group = g.ID
+
+	d, err := e.ToDay()
+	if err != nil {
+		return nil, invalidEntryError{err}
+	}
+
+	// Copy over location to day entity, as importDays does
+	d.Location = g.Location
+	return d, nil
+}
+
+// saveDay saves the external Day to the group, in place of the Day
+// with the key `replaces`, if given.  The Day may not have the same
+// key as any other Day of the group, nor, unless allowOverlap is
+// set, overlap any other.
+func saveDay(s Store, groupID, replaces string, e *DayExternal, allowOverlap bool) (ret *DayEntry, err error) {
+	err = s.Update(func(s Store) error {
+		g, err := s.Group(groupID)
+		if err != nil {
+			return err
+		}
+		d, err := dayFromExternal(g, e)
+		if err != nil {
+			return err
+		}
+		days, err := s.Days(g)
+		if err != nil {
+			return err
+		}
+
+		var replaced, overlaps []interface{}
+		found := replaces == ""
+		for i := range days {
+			o := &days[i]
+			if replaces != "" && string(o.Key()) == replaces {
+				found = true
+				continue
+			}
+			if string(o.Key()) == string(d.Key()) {
+				replaced = append(replaced, newDayEntry(o))
+			} else if o.Overlaps(d) {
+				overlaps = append(overlaps, newDayEntry(o))
+			}
+		}
+		if !found {
+			return ErrNotFound
+		}
+		if len(replaced) > 0 {
+			return &entryConflictError{Message: "Another day has the same day and start time", Conflicts: replaced}
+		}
+		if len(overlaps) > 0 {
+			if !allowOverlap {
+				return &entryConflictError{Message: "Day overlaps other days", Conflicts: overlaps}
+			}
+			Log.Warn("Saving overlapping day", "group", g.ID, "day", d.Key(), "overlaps", len(overlaps))
+		}
+
+		if replaces != "" {
+			if err = s.DeleteDay(g, replaces); err != nil {
+				return err
+			}
+		}
+		if err = s.PutDay(g, *d); err != nil {
+			return err
+		}
+		ret = newDayEntry(d)
+		return nil
+	})
+	return
+}
+
+// deleteDay deletes the Day with the given key from the group
+func deleteDay(s Store, groupID, key string) error {
+	return s.Update(func(s Store) error {
+		g, err := s.Group(groupID)
+		if err != nil {
+			return err
+		}
+		return s.DeleteDay(g, key)
+	})
+}
+
+// getDatesHandler lists the Dates of the group which overlap the
+// range given by the optional RFC3339 `from` and `to` parameters
+func getDatesHandler(ctx *echo.Context) error {
+	var from, to time.Time
+	var err error
+	if src := ctx.Query("from"); src != "" {
+		if from, err = parseAt(src); err != nil {
+			return ctx.String(400, err.Error())
+		}
+	}
+	if src := ctx.Query("to"); src != "" {
+		if to, err = parseAt(src); err != nil {
+			return ctx.String(400, err.Error())
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && !to.After(from) {
+		return ctx.String(400, "Range must end after it starts")
+	}
+
+	list, err := dateEntries(storeFromContext(ctx), ctx.Param("id"), from, to)
+	return entryResponse(ctx, 200, list, err)
+}
+
+func getDateHandler(ctx *echo.Context) error {
+	list, err := dateEntries(storeFromContext(ctx), ctx.Param("id"), time.Time{}, time.Time{})
+	if err == nil {
+		for _, e := range list {
+			if e.Key == ctx.Param("key") {
+				return ctx.JSON(200, e)
+			}
+		}
+		err = ErrNotFound
+	}
+	return entryResponse(ctx, 200, nil, err)
+}
+
+// postDateHandler adds the Date given as DateExternal JSON to the
+// group.  A Date which overlaps another is refused unless
+// `mode=warn`.
+func postDateHandler(ctx *echo.Context) error {
+	return putDate(ctx, "", 201)
+}
+
+// putDateHandler replaces the Date with the given key with the Date
+// given as DateExternal JSON, whose key may differ
+func putDateHandler(ctx *echo.Context) error {
+	return putDate(ctx, ctx.Param("key"), 200)
+}
+
+func putDate(ctx *echo.Context, replaces string, code int) error {
+	allowOverlap, err := parseOverlapMode(ctx)
+	if err != nil {
+		return ctx.String(400, err.Error())
+	}
+	var e DateExternal
+	if err = ctx.Bind(&e); err != nil {
+		return ctx.String(400, fmt.Sprintf("Failed to parse date: %s", err.Error()))
+	}
+	ret, err := saveDate(storeFromContext(ctx), ctx.Param("id"), replaces, &e, allowOverlap)
+	return entryResponse(ctx, code, ret, err)
+}
+
+func deleteDateHandler(ctx *echo.Context) error {
+	err := deleteDate(storeFromContext(ctx), ctx.Param("id"), ctx.Param("key"))
+	return entryResponse(ctx, 204, nil, err)
+}
+
+// dateEntries returns, as DateEntries, the Dates of the group which
+// end after `from` and start before `to`.  A zero time leaves that
+// end of the range open.
+func dateEntries(s Store, groupID string, from, to time.Time) ([]*DateEntry, error) {
+	g, err := s.Group(groupID)
+	if err != nil {
+		return nil, err
+	}
+	dates, err := s.DatesBetween(g, from, to)
+	if err != nil {
+		return nil, err
+	}
+	list := []*DateEntry{}
+	for i := range dates {
+		list = append(list, newDateEntry(&dates[i]))
+	}
+	return list, nil
+}
+
+// saveDate saves the external Date to the group, in place of the
+// Date with the key `replaces`, if given.  The Date may not have the
+// same key as any other Date of the group, nor, unless allowOverlap
+// is set, overlap any other.
+func saveDate(s Store, groupID, replaces string, e *DateExternal, allowOverlap bool) (ret *DateEntry, err error) {
+	err = s.Update(func(s Store) error {
+		g, err := s.Group(groupID)
+		if err != nil {
+			return err
+		}
+		if e.Group != "" && e.Group != g.ID {
+			return invalidEntryError{fmt.Errorf("Date is for group %s, not %s", e.Group, g.ID)}
+		}
+		e.Group = g.ID
+		d, err := e.ToDate(s)
+		if err != nil {
+			return invalidEntryError{err}
+		}
+
+		// The Date replaced is deleted first, and restored should the
+		// new Date be refused
+		if replaces != "" {
+			if err = s.DeleteDate(g, replaces); err != nil {
+				return err
+			}
+		}
+		dates, err := s.DatesBetween(g, d.Date.Add(-time.Second), d.Date.Add(d.Time+time.Second))
+		if err != nil {
+			return err
+		}
+
+		var replaced, overlaps []interface{}
+		for i := range dates {
+			o := &dates[i]
+			if string(o.Key()) == string(d.Key()) {
+				replaced = append(replaced, newDateEntry(o))
+			} else if o.Overlaps(d) {
+				overlaps = append(overlaps, newDateEntry(o))
+			}
+		}
+		if len(replaced) > 0 {
+			return &entryConflictError{Message: "Another date has the same start", Conflicts: replaced}
+		}
+		if len(overlaps) > 0 {
+			if !allowOverlap {
+				return &entryConflictError{Message: "Date overlaps other dates", Conflicts: overlaps}
+			}
+			Log.Warn("Saving overlapping date", "group", g.ID, "date", d.Key(), "overlaps", len(overlaps))
+		}
+
+		if err = s.PutDate(g, *d); err != nil {
+			return err
+		}
+		ret = newDateEntry(d)
+		return nil
+	})
+	return
+}
+
+// deleteDate deletes the Date with the given key from the group
+func deleteDate(s Store, groupID, key string) error {
+	return s.Update(func(s Store) error {
+		g, err := s.Group(groupID)
+		if err != nil {
+			return err
+		}
+		return s.DeleteDate(g, key)
+	})
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDayEntries(t *testing.T) {
+	Convey("Given a group with a day", t, func() {
+		s := newMemoryStore()
+		g := &Group{ID: "testDayEntries", Location: locString}
+		So(s.SaveGroup(g), ShouldBeNil)
+		monday, err := saveDay(s, g.ID, "", &DayExternal{Target: "200", Day: "Monday", Start: "09:00", Stop: "17:00"}, false)
+		So(err, ShouldBeNil)
+		So(monday.Key, ShouldEqual, "1:0540")
+		So(monday.Group, ShouldEqual, g.ID)
+
+		Convey("It should be listed, in the location of the group", func() {
+			list, err := dayEntries(s, g.ID)
+			So(err, ShouldBeNil)
+			So(list, ShouldHaveLength, 1)
+			So(list[0], ShouldResemble, monday)
+			days, _ := s.Days(g)
+			So(days[0].Location, ShouldEqual, locString)
+
+			_, err = dayEntries(s, "noSuchGroup")
+			So(err, ShouldEqual, ErrNotFound)
+		})
+
+		Convey("Adding a day which does not overlap should succeed", func() {
+			_, err := saveDay(s, g.ID, "", &DayExternal{Target: "201", Day: "Monday", Start: "17:00", Stop: "01:00"}, false)
+			So(err, ShouldBeNil)
+			list, _ := dayEntries(s, g.ID)
+			So(list, ShouldHaveLength, 2)
+		})
+
+		Convey("Adding a day with the same key should be refused", func() {
+			_, err := saveDay(s, g.ID, "", &DayExternal{Target: "201", Day: "Monday", Start: "09:00", Stop: "10:00"}, true)
+			So(err, ShouldHaveSameTypeAs, &entryConflictError{})
+			So(err.(*entryConflictError).Conflicts, ShouldHaveLength, 1)
+		})
+
+		Convey("Adding an overlapping day should be refused, unless overlaps are allowed", func() {
+			e := &DayExternal{Target: "201", Day: "Mon", Start: "12:00", Stop: "13:00"}
+			_, err := saveDay(s, g.ID, "", e, false)
+			So(err, ShouldHaveSameTypeAs, &entryConflictError{})
+			list, _ := dayEntries(s, g.ID)
+			So(list, ShouldHaveLength, 1)
+
+			_, err = saveDay(s, g.ID, "", e, true)
+			So(err, ShouldBeNil)
+			list, _ = dayEntries(s, g.ID)
+			So(list, ShouldHaveLength, 2)
+		})
+
+		Convey("Invalid days should be refused", func() {
+			_, err := saveDay(s, g.ID, "", &DayExternal{Target: "201", Day: "Caturday", Start: "12:00", Stop: "13:00"}, false)
+			So(err, ShouldHaveSameTypeAs, invalidEntryError{})
+			_, err = saveDay(s, g.ID, "", &DayExternal{Day: "Tuesday", Start: "12:00", Stop: "13:00"}, false)
+			So(err, ShouldHaveSameTypeAs, invalidEntryError{})
+			_, err = saveDay(s, g.ID, "", &DayExternal{Group: "other", Target: "201", Day: "Tuesday", Start: "12:00", Stop: "13:00"}, false)
+			So(err, ShouldHaveSameTypeAs, invalidEntryError{})
+		})
+
+		Convey("Replacing the day should change its key", func() {
+			ret, err := saveDay(s, g.ID, monday.Key, &DayExternal{Target: "202", Day: "Monday", Start: "10:00", Stop: "17:00"}, false)
+			So(err, ShouldBeNil)
+			So(ret.Key, ShouldEqual, "1:0600")
+			list, _ := dayEntries(s, g.ID)
+			So(list, ShouldHaveLength, 1)
+			So(list[0].Target, ShouldEqual, "202")
+
+			_, err = saveDay(s, g.ID, monday.Key, &DayExternal{Target: "202", Day: "Monday", Start: "10:00", Stop: "17:00"}, false)
+			So(err, ShouldEqual, ErrNotFound)
+		})
+
+		Convey("Deleting the day should leave none", func() {
+			So(deleteDay(s, g.ID, monday.Key), ShouldBeNil)
+			list, _ := dayEntries(s, g.ID)
+			So(list, ShouldBeEmpty)
+			So(deleteDay(s, g.ID, monday.Key), ShouldEqual, ErrNotFound)
+		})
+	})
+}
+
+func TestDateEntries(t *testing.T) {
+	Convey("Given a group with a date", t, func() {
+		s := newMemoryStore()
+		g := &Group{ID: "testDateEntries", Location: locString}
+		So(s.SaveGroup(g), ShouldBeNil)
+		holiday, err := saveDate(s, g.ID, "", &DateExternal{Target: "300", Date: "2016-12-25", Start: "08:00", Stop: "20:00"}, false)
+		So(err, ShouldBeNil)
+		So(holiday.Key, ShouldEqual, "2016-12-25T13:00:00.000000000Z")
+		So(holiday.Group, ShouldEqual, g.ID)
+
+		Convey("It should be listed within a range which it overlaps", func() {
+			list, err := dateEntries(s, g.ID, time.Time{}, time.Time{})
+			So(err, ShouldBeNil)
+			So(list, ShouldHaveLength, 1)
+			So(list[0], ShouldResemble, holiday)
+
+			list, _ = dateEntries(s, g.ID, time.Date(2016, 12, 25, 19, 0, 0, 0, loc), time.Time{})
+			So(list, ShouldHaveLength, 1)
+			list, _ = dateEntries(s, g.ID, time.Date(2016, 12, 25, 20, 0, 0, 0, loc), time.Time{})
+			So(list, ShouldBeEmpty)
+			list, _ = dateEntries(s, g.ID, time.Time{}, time.Date(2016, 12, 25, 8, 0, 0, 0, loc))
+			So(list, ShouldBeEmpty)
+			list, _ = dateEntries(s, g.ID, time.Date(2016, 12, 1, 0, 0, 0, 0, loc), time.Date(2017, 1, 1, 0, 0, 0, 0, loc))
+			So(list, ShouldHaveLength, 1)
+
+			_, err = dateEntries(s, "noSuchGroup", time.Time{}, time.Time{})
+			So(err, ShouldEqual, ErrNotFound)
+		})
+
+		Convey("Adding an overlapping date should be refused, unless overlaps are allowed", func() {
+			e := &DateExternal{Target: "301", Date: "2016-12-25", Start: "19:00", Stop: "23:00"}
+			_, err := saveDate(s, g.ID, "", e, false)
+			So(err, ShouldHaveSameTypeAs, &entryConflictError{})
+
+			_, err = saveDate(s, g.ID, "", e, true)
+			So(err, ShouldBeNil)
+			list, _ := dateEntries(s, g.ID, time.Time{}, time.Time{})
+			So(list, ShouldHaveLength, 2)
+		})
+
+		Convey("Adding a date with the same start should be refused", func() {
+			_, err := saveDate(s, g.ID, "", &DateExternal{Target: "301", Date: "2016-12-25", Start: "08:00", Stop: "09:00"}, true)
+			So(err, ShouldHaveSameTypeAs, &entryConflictError{})
+		})
+
+		Convey("Invalid dates should be refused", func() {
+			_, err := saveDate(s, g.ID, "", &DateExternal{Target: "301", Date: "2016-13-45", Start: "08:00", Stop: "09:00"}, false)
+			So(err, ShouldHaveSameTypeAs, invalidEntryError{})
+			_, err = saveDate(s, g.ID, "", &DateExternal{Group: "other", Target: "301", Date: "2016-12-26", Start: "08:00", Stop: "09:00"}, false)
+			So(err, ShouldHaveSameTypeAs, invalidEntryError{})
+		})
+
+		Convey("Replacing the date should change its key", func() {
+			ret, err := saveDate(s, g.ID, holiday.Key, &DateExternal{Target: "302", Date: "2016-12-26", Start: "08:00", Stop: "20:00"}, false)
+			So(err, ShouldBeNil)
+			So(ret.Key, ShouldEqual, "2016-12-26T13:00:00.000000000Z")
+			list, _ := dateEntries(s, g.ID, time.Time{}, time.Time{})
+			So(list, ShouldHaveLength, 1)
+			So(list[0].Target, ShouldEqual, "302")
+			So(getTarget(s, g.ID, time.Date(2016, 12, 26, 12, 0, 0, 0, loc)), ShouldEqual, "302")
+
+			_, err = saveDate(s, g.ID, holiday.Key, &DateExternal{Target: "302", Date: "2016-12-26", Start: "08:00", Stop: "20:00"}, false)
+			So(err, ShouldEqual, ErrNotFound)
+		})
+
+		Convey("Deleting the date should leave none", func() {
+			So(deleteDate(s, g.ID, holiday.Key), ShouldBeNil)
+			list, _ := dateEntries(s, g.ID, time.Time{}, time.Time{})
+			So(list, ShouldBeEmpty)
+			So(deleteDate(s, g.ID, holiday.Key), ShouldEqual, ErrNotFound)
+		})
+	})
+}
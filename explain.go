@@ -0,0 +1,136 @@
+package main
+
+import (
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// Resolution layers, in order of precedence
+const (
+	layerDate    = "date"
+	layerDay     = "day"
+	layerDefault = "default"
+	layerNone    = "none"
+)
+
+// TraceEntry describes a single schedule entry which
+// was examined during target resolution
+type TraceEntry struct {
+	Key    string    `json:"key"`    // BoltDB key of the entry
+	Target string    `json:"target"` // Target of the entry
+	Start  time.Time `json:"start"`  // Start of the (closest) instance of the entry
+	Stop   time.Time `json:"stop"`   // Stop of the (closest) instance of the entry
+	Active bool      `json:"active"` // Whether the entry is active at the resolution time
+}
+
+// Trace describes how the target for a group was
+// resolved at a given time
+type Trace struct {
+	Group    string       `json:"group"`    // Group ID
+	Timezone string       `json:"timezone"` // Group timezone
+	At       time.Time    `json:"at"`       // Time for which the target was resolved
+	Dates    []TraceEntry `json:"dates"`    // Date entries examined
+	Days     []TraceEntry `json:"days"`     // Day entries examined
+	Layer    string       `json:"layer"`    // Layer which supplied the target (date, day, default, none)
+	Target   string       `json:"target"`   // Resolved target
+}
+
+// explainTargetHandler returns the resolution trace for the
+// target of a group, at the present time or the time given by
+// the optional RFC3339 `at` parameter
+func explainTargetHandler(ctx *echo.Context) error {
+	at, err := parseAt(ctx.Query("at"))
+	if err != nil {
+		return ctx.String(400, err.Error())
+	}
+
+	tr, err := explainTarget(storeFromContext(ctx), ctx.Param("id"), at)
+	if err != nil {
+		if err == ErrNotFound {
+			return ctx.String(404, "Not found")
+		}
+		return ctx.String(500, err.Error())
+	}
+	return ctx.JSON(200, tr)
+}
+
+// explainTarget resolves the target for the given group and
+// time, following the same precedence as getTarget, and
+// returns a trace of every entry examined along the way.
+func explainTarget(s Store, groupID string, at time.Time) (*Trace, error) {
+	g, err := s.Group(groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	tr := &Trace{
+		Group:    g.ID,
+		Timezone: g.Location,
+		At:       at,
+		Dates:    []TraceEntry{},
+		Days:     []TraceEntry{},
+		Layer:    layerNone,
+	}
+	if loc, err := g.GetLocation(); err == nil {
+		tr.At = at.In(loc)
+	}
+
+	dates, err := s.Dates(g)
+	if err != nil {
+		return nil, err
+	}
+	var matched bool
+	for _, d := range dates {
+		e := TraceEntry{
+			Key:    string(d.Key()),
+			Target: d.Target,
+			Start:  d.Date,
+			Stop:   d.Date.Add(d.Time),
+			Active: d.ActiveAt(at),
+		}
+		tr.Dates = append(tr.Dates, e)
+
+		// As with ActiveDate, only the first active Date counts
+		if e.Active && !matched {
+			matched = true
+			if tr.Layer == layerNone && d.Target != "" {
+				tr.Layer = layerDate
+				tr.Target = d.Target
+			}
+		}
+	}
+
+	days, err := s.Days(g)
+	if err != nil {
+		return nil, err
+	}
+	matched = false
+	for _, d := range days {
+		start, stop := d.Times(at)
+		e := TraceEntry{
+			Key:    string(d.Key()),
+			Target: d.Target,
+			Start:  start,
+			Stop:   stop,
+			Active: d.ActiveAt(at),
+		}
+		tr.Days = append(tr.Days, e)
+
+		// As with ActiveDay, only the first active Day counts
+		if e.Active && !matched {
+			matched = true
+			if tr.Layer == layerNone && d.Target != "" {
+				tr.Layer = layerDay
+				tr.Target = d.Target
+			}
+		}
+	}
+
+	if tr.Layer == layerNone && g.DefaultTarget != "" {
+		tr.Layer = layerDefault
+		tr.Target = g.DefaultTarget
+	}
+
+	return tr, nil
+}
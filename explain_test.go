@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExplainTarget(t *testing.T) {
+	db, err := dbOpen("./explainTest.db")
+	if err != nil {
+		panic("Failed to open test database")
+	}
+	defer func() {
+		db.Close()
+		os.Remove("./explainTest.db")
+	}()
+
+	g := Group{
+		ID:            "testExplainGroup",
+		Name:          "testExplainGroup",
+		Location:      locString,
+		DefaultTarget: "100",
+	}
+	saveGroup(db, &g)
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		day := Day{
+			Group:    g.ID,
+			Target:   "200",
+			Day:      time.Saturday,
+			Start:    1 * time.Hour, // 01:00
+			Duration: 4 * time.Hour, // until 05:00
+			Location: g.Location,
+		}
+		if err := day.Save(tx); err != nil {
+			return err
+		}
+		date := Date{
+			Group:  g.ID,
+			Target: "300",
+			Date:   time.Date(2016, 01, 30, 1, 0, 0, 0, loc),
+			Time:   4 * time.Hour,
+		}
+		return date.Save(tx)
+	})
+	if err != nil {
+		t.Skip("Failed to write test data for TestExplainTarget", err)
+		return
+	}
+
+	Convey("Given a group with a Saturday Day, a Date on Jan 30, 2016 and a default target", t, func() {
+		Convey("At 02:00 Saturday, Jan 30, 2016 the Date layer should win", func() {
+			tr, err := explainTarget(newBoltStore(db), g.ID, time.Date(2016, 01, 30, 2, 0, 0, 0, loc))
+			So(err, ShouldBeNil)
+			So(tr.Layer, ShouldEqual, layerDate)
+			So(tr.Target, ShouldEqual, "300")
+			So(tr.Timezone, ShouldEqual, locString)
+			So(tr.Dates, ShouldHaveLength, 1)
+			So(tr.Dates[0].Active, ShouldBeTrue)
+			So(tr.Days, ShouldHaveLength, 1)
+			So(tr.Days[0].Active, ShouldBeTrue)
+		})
+		Convey("At 02:00 Saturday, Jan 23, 2016 the Day layer should win", func() {
+			tr, err := explainTarget(newBoltStore(db), g.ID, time.Date(2016, 01, 23, 2, 0, 0, 0, loc))
+			So(err, ShouldBeNil)
+			So(tr.Layer, ShouldEqual, layerDay)
+			So(tr.Target, ShouldEqual, "200")
+			So(tr.Dates[0].Active, ShouldBeFalse)
+		})
+		Convey("At 12:00 Saturday, Jan 23, 2016 the default layer should win", func() {
+			tr, err := explainTarget(newBoltStore(db), g.ID, time.Date(2016, 01, 23, 12, 0, 0, 0, loc))
+			So(err, ShouldBeNil)
+			So(tr.Layer, ShouldEqual, layerDefault)
+			So(tr.Target, ShouldEqual, "100")
+		})
+		Convey("The trace should agree with getTarget", func() {
+			at := time.Date(2016, 01, 23, 3, 30, 0, 0, loc)
+			tr, err := explainTarget(newBoltStore(db), g.ID, at)
+			So(err, ShouldBeNil)
+			So(tr.Target, ShouldEqual, getTarget(newBoltStore(db), g.ID, at))
+		})
+		Convey("An unknown group should fail with ErrNotFound", func() {
+			_, err := explainTarget(newBoltStore(db), "noSuchGroup", time.Now())
+			So(err, ShouldEqual, ErrNotFound)
+		})
+	})
+}
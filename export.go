@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/labstack/echo"
+)
+
+// Header rows of exported schedule CSVs.  The importers
+// recognize these, so an export may be uploaded unchanged.
+var (
+	dayCSVHeader  = []string{"Group ID", "Day of the Week", "Start Time (HH:MM)", "Stop Time (HH:MM)", "Target phone number"}
+	dateCSVHeader = []string{"Group ID", "Date (YYYY-MM-DD)", "Start Time (HH:MM)", "Stop Time (HH:MM)", "Target phone number"}
+)
+
+// exportDaysCSV returns the days schedule of the group (or, absent
+// an `id` parameter, of every group) as a CSV in the import format
+func exportDaysCSV(ctx *echo.Context) error {
+	s := storeFromContext(ctx)
+	groups, err := exportGroups(s, ctx.Param("id"))
+	if err != nil {
+		return csvResponse(ctx, "", nil, err)
+	}
+
+	var days []Day
+	for _, g := range groups {
+		list, err := s.Days(g)
+		if err != nil {
+			return csvResponse(ctx, "", nil, err)
+		}
+		days = append(days, list...)
+	}
+
+	var buf bytes.Buffer
+	err = writeDaysCSV(&buf, days)
+	return csvResponse(ctx, csvFilename(ctx.Param("id"), "days"), buf.Bytes(), err)
+}
+
+// exportDatesCSV returns the dates schedule of the group (or, absent
+// an `id` parameter, of every group) as a CSV in the import format
+func exportDatesCSV(ctx *echo.Context) error {
+	s := storeFromContext(ctx)
+	groups, err := exportGroups(s, ctx.Param("id"))
+	if err != nil {
+		return csvResponse(ctx, "", nil, err)
+	}
+
+	var dates []Date
+	for _, g := range groups {
+		list, err := s.Dates(g)
+		if err != nil {
+			return csvResponse(ctx, "", nil, err)
+		}
+		dates = append(dates, list...)
+	}
+
+	var buf bytes.Buffer
+	err = writeDatesCSV(&buf, dates)
+	return csvResponse(ctx, csvFilename(ctx.Param("id"), "dates"), buf.Bytes(), err)
+}
+
+// exportGroups returns the group with the given ID or, if the ID
+// is empty, every group
+func exportGroups(s Store, id string) ([]*Group, error) {
+	if id != "" {
+		g, err := s.Group(id)
+		if err != nil {
+			return nil, err
+		}
+		return []*Group{g}, nil
+	}
+	return s.Groups()
+}
+
+// writeDaysCSV writes the given Days, with a header row, in the
+// format read by importDays.  Rows are ordered by group, then by
+// day of the week and start time.
+func writeDaysCSV(w io.Writer, days []Day) error {
+	days = append([]Day(nil), days...)
+	sort.Stable(byWeekTime(days))
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(dayCSVHeader); err != nil {
+		return err
+	}
+	for _, d := range days {
+		e := d.ToExternal()
+		if err := cw.Write([]string{e.Group, e.Day, e.Start, e.Stop, e.Target}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeDatesCSV writes the given Dates, with a header row, in the
+// format read by importDates.  Rows are ordered by group, then
+// chronologically.
+func writeDatesCSV(w io.Writer, dates []Date) error {
+	dates = append([]Date(nil), dates...)
+	sort.Stable(byStart(dates))
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(dateCSVHeader); err != nil {
+		return err
+	}
+	for _, d := range dates {
+		e := d.ToExternal()
+		if err := cw.Write([]string{e.Group, e.Date, e.Start, e.Stop, e.Target}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvFilename returns the download name of an exported schedule
+func csvFilename(groupID, layer string) string {
+	if groupID == "" {
+		return layer + ".csv"
+	}
+	return fmt.Sprintf("%s-%s.csv", groupID, layer)
+}
+
+// csvResponse responds with the given CSV as a download, given the
+// error which ended its transaction
+func csvResponse(ctx *echo.Context, name string, data []byte, err error) error {
+	if err != nil {
+		if err == ErrNotFound {
+			return ctx.String(404, "Not found")
+		}
+		return ctx.String(500, err.Error())
+	}
+
+	h := ctx.Response().Header()
+	h.Set("Content-Type", "text/csv; charset=utf-8")
+	h.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	ctx.Response().WriteHeader(200)
+	_, err = ctx.Response().Write(data)
+	return err
+}
+
+// byWeekTime sorts Days by group, then by their start within the week
+type byWeekTime []Day
+
+func (b byWeekTime) Len() int      { return len(b) }
+func (b byWeekTime) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byWeekTime) Less(i, j int) bool {
+	if b[i].Group != b[j].Group {
+		return b[i].Group < b[j].Group
+	}
+	si, _ := b[i].weekSpan()
+	sj, _ := b[j].weekSpan()
+	return si < sj
+}
+
+// byStart sorts Dates by group, then by their start
+type byStart []Date
+
+func (b byStart) Len() int      { return len(b) }
+func (b byStart) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byStart) Less(i, j int) bool {
+	if b[i].Group != b[j].Group {
+		return b[i].Group < b[j].Group
+	}
+	return b[i].Date.Before(b[j].Date)
+}
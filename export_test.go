@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFormatTime(t *testing.T) {
+	Convey("formatTime should render durations as HH:mm", t, func() {
+		So(formatTime(2*time.Hour), ShouldEqual, "02:00")
+		So(formatTime(13*time.Hour+5*time.Minute), ShouldEqual, "13:05")
+		So(formatTime(26*time.Hour), ShouldEqual, "02:00")
+		So(formatTime(time.Hour+30*time.Second), ShouldEqual, "01:00:30")
+	})
+}
+
+func TestDaysCSVRoundTrip(t *testing.T) {
+	Convey("Given Days including an overnight shift", t, func() {
+		days := []Day{
+			Day{Group: "b", Target: "3", Day: time.Sunday, Start: 2*time.Hour + 30*time.Minute, Duration: time.Hour},
+			Day{Group: "a", Target: "2", Day: time.Monday, Start: 22 * time.Hour, Duration: 4 * time.Hour},
+			Day{Group: "a", Target: "1", Day: time.Monday, Start: 9 * time.Hour, Duration: 8 * time.Hour},
+		}
+		sorted := []Day{days[2], days[1], days[0]}
+
+		var buf bytes.Buffer
+		So(writeDaysCSV(&buf, days), ShouldBeNil)
+		exported := buf.String()
+
+		Convey("The export should begin with a recognized header", func() {
+			recs, err := csv.NewReader(bytes.NewBufferString(exported)).ReadAll()
+			So(err, ShouldBeNil)
+			So(recs, ShouldHaveLength, 4)
+			_, isHeader, err := newCSVColumns(dayFields, "", recs[0])
+			So(err, ShouldBeNil)
+			So(isHeader, ShouldBeTrue)
+			So(recs[1], ShouldResemble, []string{"a", "Monday", "09:00", "17:00", "1"})
+			So(recs[2], ShouldResemble, []string{"a", "Monday", "22:00", "02:00", "2"})
+		})
+
+		Convey("Importing the rows should restore the same Days", func() {
+			recs, _ := csv.NewReader(bytes.NewBufferString(exported)).ReadAll()
+			var restored []Day
+			for _, rec := range recs[1:] {
+				d, err := NewDayFromCSVRow(rec)
+				So(err, ShouldBeNil)
+				restored = append(restored, *d)
+			}
+			So(restored, ShouldResemble, sorted)
+
+			Convey("And exporting them again should produce the same bytes", func() {
+				var again bytes.Buffer
+				So(writeDaysCSV(&again, restored), ShouldBeNil)
+				So(again.String(), ShouldEqual, exported)
+			})
+		})
+	})
+}
+
+func TestDatesCSVRoundTrip(t *testing.T) {
+	db, err := dbOpen("./exportTest.db")
+	if err != nil {
+		panic("Failed to open test database")
+	}
+	defer func() {
+		db.Close()
+		os.Remove("./exportTest.db")
+	}()
+
+	g := Group{ID: "testExportGroup", Name: "testExportGroup", Location: locString}
+	saveGroup(db, &g)
+
+	Convey("Given Dates on and around a DST transition", t, func() {
+		var dates []Date
+		for _, e := range []DateExternal{
+			{Group: g.ID, Target: "2", Date: "2016-03-13", Start: "01:00", Stop: "05:00"},
+			{Group: g.ID, Target: "1", Date: "2016-03-12", Start: "22:00", Stop: "01:00"},
+		} {
+			d, err := e.ToDate(newBoltStore(db))
+			So(err, ShouldBeNil)
+			dates = append(dates, *d)
+		}
+
+		var buf bytes.Buffer
+		So(writeDatesCSV(&buf, dates), ShouldBeNil)
+		exported := buf.String()
+
+		Convey("Importing the rows should restore the same Dates", func() {
+			recs, err := csv.NewReader(bytes.NewBufferString(exported)).ReadAll()
+			So(err, ShouldBeNil)
+			So(recs, ShouldHaveLength, 3)
+			So(recs[1], ShouldResemble, []string{g.ID, "2016-03-12", "22:00", "01:00", "1"})
+			So(recs[2], ShouldResemble, []string{g.ID, "2016-03-13", "01:00", "05:00", "2"})
+
+			var restored []Date
+			for _, rec := range recs[1:] {
+				d, err := NewDateFromCSV(newBoltStore(db), rec)
+				So(err, ShouldBeNil)
+				restored = append(restored, *d)
+			}
+			So(restored, ShouldHaveLength, 2)
+			So(restored[0].Date.Equal(dates[1].Date), ShouldBeTrue) // exported chronologically
+			So(restored[0].Time, ShouldEqual, dates[1].Time)
+			So(restored[1].Date.Equal(dates[0].Date), ShouldBeTrue)
+			So(restored[1].Time, ShouldEqual, dates[0].Time)
+
+			Convey("And exporting them again should produce the same bytes", func() {
+				var again bytes.Buffer
+				So(writeDatesCSV(&again, restored), ShouldBeNil)
+				So(again.String(), ShouldEqual, exported)
+			})
+		})
+	})
+
+	Convey("Given Dates of a day or more, as imported from all-day events", t, func() {
+		dates := []Date{
+			{Group: g.ID, Target: "3", Date: time.Date(2016, 11, 6, 0, 0, 0, 0, loc), Time: 25 * time.Hour},
+			{Group: g.ID, Target: "4", Date: time.Date(2016, 12, 25, 0, 0, 0, 0, loc), Time: 24 * time.Hour},
+			{Group: g.ID, Target: "5", Date: time.Date(2016, 12, 27, 9, 0, 0, 0, loc), Time: 56 * time.Hour},
+		}
+
+		var buf bytes.Buffer
+		So(writeDatesCSV(&buf, dates), ShouldBeNil)
+		exported := buf.String()
+
+		Convey("They should be exported with the date of their stop", func() {
+			recs, err := csv.NewReader(bytes.NewBufferString(exported)).ReadAll()
+			So(err, ShouldBeNil)
+			So(recs, ShouldHaveLength, 4)
+			So(recs[1], ShouldResemble, []string{g.ID, "2016-11-06", "00:00", "2016-11-07 00:00", "3"})
+			So(recs[2], ShouldResemble, []string{g.ID, "2016-12-25", "00:00", "2016-12-26 00:00", "4"})
+			So(recs[3], ShouldResemble, []string{g.ID, "2016-12-27", "09:00", "2016-12-29 17:00", "5"})
+
+			Convey("And importing them should restore the same Dates", func() {
+				for i, rec := range recs[1:] {
+					d, err := NewDateFromCSV(newBoltStore(db), rec)
+					So(err, ShouldBeNil)
+					So(d.Date.Equal(dates[i].Date), ShouldBeTrue)
+					So(d.Time, ShouldEqual, dates[i].Time)
+				}
+			})
+		})
+	})
+}
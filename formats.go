@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Formats of an uploaded schedule
+const (
+	formatCSV  = "csv"
+	formatJSON = "json"
+	formatYAML = "yaml"
+	formatICal = "ical"
+)
+
+// bodyFormats are the formats of schedules which may be uploaded
+// as the request body, by media type
+var bodyFormats = map[string]string{
+	"text/csv":           formatCSV,
+	"text/calendar":      formatICal,
+	"application/json":   formatJSON,
+	"application/yaml":   formatYAML,
+	"application/x-yaml": formatYAML,
+	"text/yaml":          formatYAML,
+	"text/x-yaml":        formatYAML,
+}
+
+// fileFormats are the formats of schedules which may be uploaded as
+// a (multipart) file, by extension.  Other files are read as CSV.
+var fileFormats = map[string]string{
+	".json": formatJSON,
+	".yaml": formatYAML,
+	".yml":  formatYAML,
+	".ics":  formatICal,
+}
+
+// mediaType returns the media type of a Content-Type header
+func mediaType(contentType string) string {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return t
+}
+
+// fileFormat returns the format of an uploaded file, by its name
+func fileFormat(name string) string {
+	if f, ok := fileFormats[strings.ToLower(path.Ext(name))]; ok {
+		return f
+	}
+	return formatCSV
+}
+
+// readRecords reads the entries of an uploaded schedule in the given
+// format, passing the fields of each to fn as readCSV does
+func readRecords(file io.Reader, format string, fields []string, opts *importOptions, ret *ImportResult, fn func(row int, rec []string, guessHeader bool) error) error {
+	switch format {
+	case formatCSV:
+		return readCSV(file, fields, opts, ret, fn)
+	case formatJSON, formatYAML:
+		return readStructured(file, format, fields, ret, fn)
+	default:
+		ret.addRow(1, "", rowError, fmt.Errorf("Cannot import a %s file here", format))
+		return nil
+	}
+}
+
+// readStructured reads the entries of a JSON or YAML schedule, which
+// is either a list of external (DayExternal or DateExternal) entries
+// or a ScheduleDump, passing the fields of each to fn in positional
+// order.  Entries are numbered from 1.
+func readStructured(file io.Reader, format string, fields []string, ret *ImportResult, fn func(row int, rec []string, guessHeader bool) error) error {
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	if format == formatYAML {
+		if data, err = yamlToJSON(data); err != nil {
+			ret.addRow(1, "", rowError, fmt.Errorf("Failed to parse YAML: %s", err.Error()))
+			return nil
+		}
+	}
+
+	entries, err := structuredEntries(data, fields)
+	if err != nil {
+		ret.addRow(1, "", rowError, err)
+		return nil
+	}
+	for i, e := range entries {
+		rec := make([]string, len(fields))
+		for j, f := range fields {
+			rec[j] = e[f]
+		}
+		if err := fn(i+1, rec, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// structuredEntries decodes the entries of a JSON schedule, by field
+func structuredEntries(data []byte, fields []string) ([]map[string]string, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		return dumpEntries(data, fields)
+	}
+
+	var list []map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&list); err != nil {
+		return nil, fmt.Errorf("Failed to parse schedule: %s", err.Error())
+	}
+
+	ret := make([]map[string]string, len(list))
+	for i, item := range list {
+		ret[i] = make(map[string]string)
+		for k, v := range item {
+			if v != nil {
+				ret[i][strings.ToLower(k)] = strings.TrimSpace(fmt.Sprint(v))
+			}
+		}
+	}
+	return ret, nil
+}
+
+// dumpEntries decodes the days (or, if the fields have no day, the
+// dates) of a ScheduleDump.  Entries without a group take the group
+// of the dump.
+func dumpEntries(data []byte, fields []string) ([]map[string]string, error) {
+	var dump ScheduleDump
+	if err := json.Unmarshal(data, &dump); err != nil {
+		return nil, fmt.Errorf("Failed to parse schedule dump: %s", err.Error())
+	}
+	var group string
+	if dump.Group != nil {
+		group = dump.Group.ID
+	}
+
+	var ret []map[string]string
+	if hasField(fields, "day") {
+		for _, d := range dump.Days {
+			e := d.ToExternal()
+			ret = append(ret, map[string]string{"group": e.Group, "day": e.Day, "start": e.Start, "stop": e.Stop, "target": e.Target})
+		}
+	} else {
+		for _, d := range dump.Dates {
+			e := d.ToExternal()
+			ret = append(ret, map[string]string{"group": e.Group, "date": e.Date, "start": e.Start, "stop": e.Stop, "target": e.Target})
+		}
+	}
+	for _, e := range ret {
+		if e["group"] == "" {
+			e["group"] = group
+		}
+	}
+	return ret, nil
+}
+
+// yamlToJSON converts a YAML document to JSON
+func yamlToJSON(data []byte) ([]byte, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonValue(v))
+}
+
+// jsonValue converts a value decoded from YAML to one which may be
+// encoded as JSON:  maps are keyed by strings.  (Timestamps decoded
+// into interface{} are left as strings.)
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[fmt.Sprint(k)] = jsonValue(val)
+		}
+		return m
+	case []interface{}:
+		for i, val := range v {
+			v[i] = jsonValue(val)
+		}
+		return v
+	default:
+		return v
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUploadFormats(t *testing.T) {
+	Convey("Body formats should be recognized by media type", t, func() {
+		So(bodyFormats[mediaType("application/json; charset=utf-8")], ShouldEqual, formatJSON)
+		So(bodyFormats[mediaType("Application/X-YAML")], ShouldEqual, formatYAML)
+		So(bodyFormats[mediaType("text/csv")], ShouldEqual, formatCSV)
+		_, ok := bodyFormats[mediaType("multipart/form-data; boundary=x")]
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("File formats should be recognized by extension", t, func() {
+		So(fileFormat("schedule.JSON"), ShouldEqual, formatJSON)
+		So(fileFormat("schedule.yml"), ShouldEqual, formatYAML)
+		So(fileFormat("oncall.ics"), ShouldEqual, formatICal)
+		So(fileFormat("schedule.csv"), ShouldEqual, formatCSV)
+		So(fileFormat("schedule"), ShouldEqual, formatCSV)
+	})
+}
+
+func TestReadStructured(t *testing.T) {
+	read := func(src, format string, fields []string) ([][]string, *ImportResult) {
+		ret := newImportResult(&importOptions{})
+		var recs [][]string
+		err := readRecords(strings.NewReader(src), format, fields, &importOptions{}, ret, func(row int, rec []string, guessHeader bool) error {
+			So(guessHeader, ShouldBeFalse)
+			So(row, ShouldEqual, len(recs)+1)
+			recs = append(recs, rec)
+			return nil
+		})
+		So(err, ShouldBeNil)
+		return recs, ret
+	}
+
+	Convey("Given a JSON list of DayExternals", t, func() {
+		recs, ret := read(`[
+			{"group": "a", "day": "Monday", "start": "09:00", "stop": "17:00", "target": "100"},
+			{"group": "a", "day": 2, "start": "22:00", "stop": "02:00", "target": 5551234}
+		]`, formatJSON, dayFields)
+
+		Convey("Each entry should be passed in positional order", func() {
+			So(ret.Errors, ShouldBeEmpty)
+			So(recs, ShouldResemble, [][]string{
+				{"a", "Monday", "09:00", "17:00", "100"},
+				{"a", "2", "22:00", "02:00", "5551234"},
+			})
+		})
+	})
+
+	Convey("Given a YAML list of DateExternals", t, func() {
+		recs, ret := read(`
+- group: a
+  date: 2016-03-13
+  start: "01:00"
+  stop: "05:00"
+  target: "100"
+`, formatYAML, dateFields)
+
+		Convey("Dates should be passed as YYYY-MM-DD", func() {
+			So(ret.Errors, ShouldBeEmpty)
+			So(recs, ShouldResemble, [][]string{{"a", "2016-03-13", "01:00", "05:00", "100"}})
+		})
+	})
+
+	Convey("Given a ScheduleDump", t, func() {
+		src := `{
+			"Group": {"id": "a", "timezone": "US/Eastern"},
+			"Dates": [{"Target": "300", "Date": "2016-03-13T01:00:00-05:00", "Time": 14400000000000}],
+			"Days": [{"Group": "a", "Target": "200", "Day": 6, "Start": 3600000000000, "Duration": 14400000000000}]
+		}`
+
+		Convey("A days import should read its Days", func() {
+			recs, ret := read(src, formatJSON, dayFields)
+			So(ret.Errors, ShouldBeEmpty)
+			So(recs, ShouldResemble, [][]string{{"a", time.Saturday.String(), "01:00", "05:00", "200"}})
+		})
+
+		Convey("A dates import should read its Dates, in the group of the dump", func() {
+			recs, ret := read(src, formatJSON, dateFields)
+			So(ret.Errors, ShouldBeEmpty)
+			So(recs, ShouldResemble, [][]string{{"a", "2016-03-13", "01:00", "05:00", "300"}})
+		})
+	})
+
+	Convey("Malformed documents and unsupported formats should be reported as errors", t, func() {
+		_, ret := read(`{"Days": 1}`, formatJSON, dayFields)
+		So(ret.Errors, ShouldHaveLength, 1)
+		_, ret = read("- [unbalanced", formatYAML, dayFields)
+		So(ret.Errors, ShouldHaveLength, 1)
+		_, ret = read("BEGIN:VCALENDAR", formatICal, dayFields)
+		So(ret.Errors, ShouldHaveLength, 1)
+	})
+}
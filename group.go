@@ -3,6 +3,8 @@ package main
 import (
 	"bytes"
 	"encoding/gob"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -56,25 +58,108 @@ func (g *Group) ClearDates(tx *bolt.Tx) error {
 		return err
 	}
 
+	// Bolt refuses to delete a missing key which sorts before a
+	// bucket, such as dateSpan before dates
+	if b.Get(dateSpanKey) == nil {
+		return nil
+	}
+	return b.Delete(dateSpanKey)
+}
+
+// ErrGroupExists indicates that a group with the same ID
+// already exists
+var ErrGroupExists = errors.New("Group already exists")
+
+// invalidGroupError indicates that a group is not valid, and was
+// not saved
+type invalidGroupError struct {
+	error
+}
+
+// validate checks that the group has an ID, which is not the name
+// of a reserved bucket, and a valid timezone
+func (g *Group) validate() error {
+	if g.ID == "" {
+		return invalidGroupError{errors.New("Group ID is mandatory")}
+	}
+	if isReservedBucket(g.Key()) {
+		return invalidGroupError{fmt.Errorf("Group ID %s is reserved", g.ID)}
+	}
+	if g.Location == "" {
+		return invalidGroupError{errors.New("Timezone is mandatory")}
+	}
+	if _, err := g.GetLocation(); err != nil {
+		return invalidGroupError{fmt.Errorf("Invalid timezone %s: %s", g.Location, err.Error())}
+	}
 	return nil
 }
 
-// allGroups returns the list of all groups
-func allGroups(db *bolt.DB) (list []*Group, err error) {
-	list = []*Group{}
-	db.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket(groupBucket).Cursor()
-		if c == nil {
-			return fmt.Errorf("No groups found")
+// GroupUpdate is a partial update of a group:  only the fields
+// which are given are changed
+type GroupUpdate struct {
+	Name          *string `json:"name"`
+	Location      *string `json:"timezone"`
+	DefaultTarget *string `json:"defaultTarget"`
+}
+
+// createGroup validates and saves the new group, which must not
+// already exist
+func createGroup(s Store, g *Group) error {
+	if err := g.validate(); err != nil {
+		return err
+	}
+	return s.Update(func(s Store) error {
+		if _, err := s.Group(g.ID); err != ErrNotFound {
+			if err == nil {
+				return ErrGroupExists
+			}
+			return err
 		}
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			var g Group
-			err = decodeGroup(v, &g)
+		return s.SaveGroup(g)
+	})
+}
+
+// updateGroup applies the update to the group with the given ID,
+// returning the updated group.  If its timezone changes, so does the
+// location of each of its Days, which copy it.
+func updateGroup(s Store, id string, u *GroupUpdate) (ret *Group, err error) {
+	err = s.Update(func(s Store) error {
+		g, err := s.Group(id)
+		if err != nil {
+			return err
+		}
+		oldLocation := g.Location
+
+		if u.Name != nil {
+			g.Name = *u.Name
+		}
+		if u.Location != nil {
+			g.Location = *u.Location
+		}
+		if u.DefaultTarget != nil {
+			g.DefaultTarget = *u.DefaultTarget
+		}
+		if err = g.validate(); err != nil {
+			return err
+		}
+		if err = s.SaveGroup(g); err != nil {
+			return err
+		}
+
+		if g.Location != oldLocation {
+			days, err := s.Days(g)
 			if err != nil {
 				return err
 			}
-			list = append(list, &g)
+			for i := range days {
+				days[i].Location = g.Location
+			}
+			Log.Info("Moving days to new timezone", "group", g.ID, "timezone", g.Location, "days", len(days))
+			if err = s.ReplaceDays(g, days); err != nil {
+				return err
+			}
 		}
+		ret = g
 		return nil
 	})
 	return
@@ -108,22 +193,43 @@ func saveGroup(db *bolt.DB, g *Group) error {
 	})
 }
 
-func deleteGroup(db *bolt.DB, id string) error {
-	if id == "" {
-		return fmt.Errorf("Cannot delete nothing")
-	}
-	g := &Group{ID: id}
-	return db.Update(func(tx *bolt.Tx) error {
-		return tx.Bucket(groupBucket).Delete(g.Key())
-	})
+// groupRecord is the stored encoding of a Group
+type groupRecord struct {
+	V             int    `json:"v"`
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	Timezone      string `json:"timezone"`
+	DefaultTarget string `json:"defaultTarget,omitempty"`
 }
 
 func encodeGroup(g *Group) ([]byte, error) {
-	var buf bytes.Buffer
-	err := gob.NewEncoder(&buf).Encode(g)
-	return buf.Bytes(), err
+	return json.Marshal(&groupRecord{
+		V:             recordVersion,
+		ID:            g.ID,
+		Name:          g.Name,
+		Timezone:      g.Location,
+		DefaultTarget: g.DefaultTarget,
+	})
 }
 
+// decodeGroup decodes a stored Group, which may be a legacy
+// gob-encoded record
 func decodeGroup(data []byte, g *Group) error {
-	return gob.NewDecoder(bytes.NewReader(data)).Decode(g)
+	if isGobRecord(data) {
+		return gob.NewDecoder(bytes.NewReader(data)).Decode(g)
+	}
+	var r groupRecord
+	if err := json.Unmarshal(data, &r); err != nil {
+		return err
+	}
+	if err := checkRecordVersion(r.V); err != nil {
+		return err
+	}
+	*g = Group{
+		ID:            r.ID,
+		Name:          r.Name,
+		Location:      r.Timezone,
+		DefaultTarget: r.DefaultTarget,
+	}
+	return nil
 }
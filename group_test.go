@@ -45,7 +45,7 @@ func TestGroups(t *testing.T) {
 
 	Convey("Given an empty group bucket", t, func() {
 		Convey("allGroups should return an empty list", func() {
-			list, err := allGroups(db)
+			list, err := newBoltStore(db).Groups()
 			So(err, ShouldBeNil)
 			So(list, ShouldBeEmpty)
 		})
@@ -65,7 +65,7 @@ func TestGroups(t *testing.T) {
 				So(g.Location, ShouldEqual, loc.String())
 			})
 			Convey("allGroups should return a list with a single group", func() {
-				list, err := allGroups(db)
+				list, err := newBoltStore(db).Groups()
 				So(err, ShouldBeNil)
 				So(len(list), ShouldEqual, 1)
 				So(list[0].ID, ShouldEqual, tg.ID)
@@ -73,3 +73,56 @@ func TestGroups(t *testing.T) {
 		})
 	})
 }
+
+func TestGroupUpdate(t *testing.T) {
+	Convey("Given a group with a day", t, func() {
+		s := newMemoryStore()
+		g := &Group{ID: "testGroupUpdate", Name: "testGroup", Location: locString, DefaultTarget: "100"}
+		So(createGroup(s, g), ShouldBeNil)
+		So(s.ReplaceDays(g, []Day{Day{Target: "200", Day: time.Monday, Start: 9 * time.Hour, Duration: 8 * time.Hour, Location: g.Location}}), ShouldBeNil)
+
+		Convey("Creating a group with the same ID should be refused", func() {
+			err := createGroup(s, &Group{ID: g.ID, Location: locString})
+			So(err, ShouldEqual, ErrGroupExists)
+			ret, _ := s.Group(g.ID)
+			So(ret.Name, ShouldEqual, "testGroup")
+		})
+
+		Convey("Creating an invalid group should be refused", func() {
+			So(createGroup(s, &Group{ID: "other", Location: "Mars/Olympus"}), ShouldHaveSameTypeAs, invalidGroupError{})
+			So(createGroup(s, &Group{ID: "other"}), ShouldHaveSameTypeAs, invalidGroupError{})
+			So(createGroup(s, &Group{ID: string(groupBucket), Location: locString}), ShouldHaveSameTypeAs, invalidGroupError{})
+		})
+
+		Convey("Updating only the name should leave the rest", func() {
+			name := "renamed"
+			ret, err := updateGroup(s, g.ID, &GroupUpdate{Name: &name})
+			So(err, ShouldBeNil)
+			So(ret.Name, ShouldEqual, name)
+			So(ret.DefaultTarget, ShouldEqual, "100")
+			So(ret.Location, ShouldEqual, locString)
+		})
+
+		Convey("Updating to an invalid timezone should be refused", func() {
+			tz := "Mars/Olympus"
+			_, err := updateGroup(s, g.ID, &GroupUpdate{Location: &tz})
+			So(err, ShouldHaveSameTypeAs, invalidGroupError{})
+			ret, _ := s.Group(g.ID)
+			So(ret.Location, ShouldEqual, locString)
+		})
+
+		Convey("Changing the timezone should move the days", func() {
+			tz := "US/Pacific"
+			_, err := updateGroup(s, g.ID, &GroupUpdate{Location: &tz})
+			So(err, ShouldBeNil)
+			days, _ := s.Days(g)
+			So(days, ShouldHaveLength, 1)
+			So(days[0].Location, ShouldEqual, tz)
+		})
+
+		Convey("Updating a missing group should fail", func() {
+			_, err := updateGroup(s, "noSuchGroup", &GroupUpdate{})
+			So(err, ShouldEqual, ErrNotFound)
+		})
+	})
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// icalProdID identifies this service as the producer of iCalendar feeds
+const icalProdID = "-//CyCore Systems//ipc-schedule//EN"
+
+// icalTimezoneYears is the number of years, after the current one,
+// over which the yearly rules of the time zone of a feed are checked
+// (or, if it has none, for which its transitions are listed)
+const icalTimezoneYears = 5
+
+// Formats of iCalendar DATE-TIME values
+const (
+	icalLocalTime = "20060102T150405"
+	icalUTCTime   = "20060102T150405Z"
+)
+
+// icalWeekdays are the iCalendar abbreviations of the days of the week
+var icalWeekdays = [...]string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// getICalHandler returns the schedule of a group as an iCalendar
+// (RFC 5545) feed.  The group ID may be given with an `.ics` suffix.
+func getICalHandler(ctx *echo.Context) error {
+	id := strings.TrimSuffix(ctx.Param("id"), ".ics")
+
+	var buf bytes.Buffer
+	err := func() error {
+		s := storeFromContext(ctx)
+		g, err := s.Group(id)
+		if err != nil {
+			return err
+		}
+		dates, err := s.Dates(g)
+		if err != nil {
+			return err
+		}
+		days, err := s.Days(g)
+		if err != nil {
+			return err
+		}
+		return writeICal(&buf, g, dates, days, time.Now())
+	}()
+	if err != nil {
+		if err == ErrNotFound {
+			return ctx.String(404, "Not found")
+		}
+		return ctx.String(500, err.Error())
+	}
+
+	h := ctx.Response().Header()
+	h.Set("Content-Type", "text/calendar; charset=utf-8")
+	h.Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", id+".ics"))
+	ctx.Response().WriteHeader(200)
+	_, err = ctx.Response().Write(buf.Bytes())
+	return err
+}
+
+// writeICal writes the schedule of the group as an iCalendar feed,
+// in the group's time zone.  Each Date is a single event and each
+// Day is a weekly recurring event, starting with its most recent
+// instance as of `now`.
+func writeICal(w io.Writer, g *Group, dates []Date, days []Day, now time.Time) error {
+	loc, err := g.GetLocation()
+	if err != nil {
+		return fmt.Errorf("Failed to get group location: %s", err.Error())
+	}
+	name := g.Name
+	if name == "" {
+		name = g.ID
+	}
+	stamp := now.UTC().Format(icalUTCTime)
+
+	iw := &icalWriter{w: w}
+	iw.line("BEGIN:VCALENDAR")
+	iw.line("VERSION:2.0")
+	iw.line("PRODID:" + icalProdID)
+	iw.line("CALSCALE:GREGORIAN")
+	iw.line("METHOD:PUBLISH")
+	iw.line("X-WR-CALNAME:" + icalText(name))
+	iw.line("X-WR-TIMEZONE:" + loc.String())
+
+	// List the time zone transitions from the earliest Date onward
+	from := now.In(loc)
+	for _, d := range dates {
+		if d.Date.Before(from) {
+			from = d.Date.In(loc)
+		}
+	}
+	writeVTimezone(iw, loc, from.Year(), now.In(loc).Year())
+
+	for _, d := range dates {
+		start := d.Date.In(loc)
+		iw.line("BEGIN:VEVENT")
+		iw.line(fmt.Sprintf("UID:date-%s-%d@ipc-schedule", g.ID, start.Unix()))
+		iw.line("DTSTAMP:" + stamp)
+		iw.line(fmt.Sprintf("DTSTART;TZID=%s:%s", loc, start.Format(icalLocalTime)))
+		iw.line(fmt.Sprintf("DTEND;TZID=%s:%s", loc, start.Add(d.Time).Format(icalLocalTime)))
+		iw.line("SUMMARY:" + icalText(fmt.Sprintf("%s: %s", name, d.Target)))
+		iw.line("END:VEVENT")
+	}
+
+	for _, d := range days {
+		d.Location = g.Location
+		start, _ := d.Times(now)
+		iw.line("BEGIN:VEVENT")
+		iw.line(fmt.Sprintf("UID:day-%s-%d-%d@ipc-schedule", g.ID, d.Day, int(d.Start/time.Minute)))
+		iw.line("DTSTAMP:" + stamp)
+		iw.line(fmt.Sprintf("DTSTART;TZID=%s:%s", loc, start.Format(icalLocalTime)))
+		iw.line("DURATION:" + icalDuration(d.Duration))
+		iw.line("RRULE:FREQ=WEEKLY;BYDAY=" + icalWeekdays[d.Day])
+		iw.line("SUMMARY:" + icalText(fmt.Sprintf("%s: %s", name, d.Target)))
+		iw.line("END:VEVENT")
+	}
+
+	iw.line("END:VCALENDAR")
+	return iw.err
+}
+
+// writeVTimezone writes the VTIMEZONE component for the given
+// location.  Its transitions before the current year are listed,
+// and those from the current year onward recur yearly by the rules
+// of the current year, so that open-ended weekly events keep the
+// right offset.  A location whose transitions follow no such rules
+// has them listed up to icalTimezoneYears ahead, and a location
+// without transitions is described by its fixed offset.
+func writeVTimezone(iw *icalWriter, loc *time.Location, fromYear, year int) {
+	iw.line("BEGIN:VTIMEZONE")
+	iw.line("TZID:" + loc.String())
+
+	start := time.Date(fromYear, time.January, 1, 0, 0, 0, 0, loc)
+	current := time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
+	past := zoneTransitions(start, current)
+	rules := zoneTransitions(current, current.AddDate(1, 0, 0))
+	if !zoneRulesHold(rules, year+1, year+icalTimezoneYears) {
+		rules = nil
+		past = zoneTransitions(start, time.Date(year+icalTimezoneYears+1, time.January, 1, 0, 0, 0, 0, loc))
+	}
+
+	if len(past) == 0 && len(rules) == 0 {
+		abbr, offset := start.Zone()
+		iw.line("BEGIN:STANDARD")
+		iw.line("DTSTART:19700101T000000")
+		iw.line("TZOFFSETFROM:" + icalOffset(offset))
+		iw.line("TZOFFSETTO:" + icalOffset(offset))
+		iw.line("TZNAME:" + icalText(abbr))
+		iw.line("END:STANDARD")
+	}
+	for _, t := range past {
+		writeObservance(iw, t, "")
+	}
+	for _, t := range rules {
+		r := zoneRuleOf(t)
+		writeObservance(iw, t, fmt.Sprintf("FREQ=YEARLY;BYMONTH=%d;BYDAY=%d%s", r.month, r.week, icalWeekdays[r.weekday]))
+	}
+
+	iw.line("END:VTIMEZONE")
+}
+
+// writeObservance writes the STANDARD or DAYLIGHT observance which
+// begins at the transition t, recurring by rrule, if given
+func writeObservance(iw *icalWriter, t time.Time, rrule string) {
+	_, before := t.Add(-time.Second).Zone()
+	abbr, after := t.Zone()
+
+	// The larger offset of a pair of transitions is daylight time
+	kind := "STANDARD"
+	if after > before {
+		kind = "DAYLIGHT"
+	}
+	iw.line("BEGIN:" + kind)
+	iw.line("DTSTART:" + t.In(time.FixedZone("", before)).Format(icalLocalTime))
+	if rrule != "" {
+		iw.line("RRULE:" + rrule)
+	}
+	iw.line("TZOFFSETFROM:" + icalOffset(before))
+	iw.line("TZOFFSETTO:" + icalOffset(after))
+	iw.line("TZNAME:" + icalText(abbr))
+	iw.line("END:" + kind)
+}
+
+// zoneRule is the yearly rule of a time zone transition:  at a
+// local time on the nth (or, if week is -1, the last) weekday of a
+// month, between the same offsets
+type zoneRule struct {
+	month         time.Month
+	week          int
+	weekday       time.Weekday
+	clock         time.Duration
+	before, after int
+}
+
+// zoneRuleOf returns the zoneRule of the transition t, in the local
+// time before it
+func zoneRuleOf(t time.Time) zoneRule {
+	r := zoneRule{}
+	_, r.before = t.Add(-time.Second).Zone()
+	_, r.after = t.Zone()
+	local := t.In(time.FixedZone("", r.before))
+
+	r.month = local.Month()
+	r.weekday = local.Weekday()
+	r.clock = local.Sub(time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location()))
+	r.week = (local.Day()-1)/7 + 1
+	if local.AddDate(0, 0, 7).Month() != local.Month() {
+		r.week = -1
+	}
+	return r
+}
+
+// zoneRulesHold says whether the transitions of each of the given
+// years, of the location of the transitions, follow the rules of
+// the given transitions, of a single year
+func zoneRulesHold(transitions []time.Time, fromYear, toYear int) bool {
+	if len(transitions) == 0 {
+		return false
+	}
+	loc := transitions[0].Location()
+	for y := fromYear; y <= toYear; y++ {
+		start := time.Date(y, time.January, 1, 0, 0, 0, 0, loc)
+		next := zoneTransitions(start, start.AddDate(1, 0, 0))
+		if len(next) != len(transitions) {
+			return false
+		}
+		for i := range next {
+			if zoneRuleOf(next[i]) != zoneRuleOf(transitions[i]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// zoneTransitions returns the instants within the given range at
+// which the UTC offset of its location changes
+func zoneTransitions(from, to time.Time) (ret []time.Time) {
+	_, prev := from.Zone()
+	for t := from; t.Before(to); t = t.Add(24 * time.Hour) {
+		next := t.Add(24 * time.Hour)
+		if _, offset := next.Zone(); offset == prev {
+			continue
+		}
+
+		// Narrow the transition down to the second
+		lo, hi := t, next
+		for hi.Sub(lo) > time.Second {
+			mid := lo.Add(hi.Sub(lo) / 2)
+			if _, offset := mid.Zone(); offset == prev {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		ret = append(ret, hi)
+		_, prev = hi.Zone()
+	}
+	return
+}
+
+// icalOffset formats a UTC offset, in seconds, as an iCalendar
+// UTC-OFFSET value
+func icalOffset(offset int) string {
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	s := fmt.Sprintf("%s%02d%02d", sign, offset/3600, offset%3600/60)
+	if offset%60 != 0 {
+		s += fmt.Sprintf("%02d", offset%60)
+	}
+	return s
+}
+
+// icalDuration formats a duration as an iCalendar DURATION value
+func icalDuration(d time.Duration) string {
+	if d <= 0 {
+		return "PT0S"
+	}
+	s := "PT"
+	if h := int(d / time.Hour); h > 0 {
+		s += fmt.Sprintf("%dH", h)
+	}
+	if m := int(d % time.Hour / time.Minute); m > 0 {
+		s += fmt.Sprintf("%dM", m)
+	}
+	if sec := int(d % time.Minute / time.Second); sec > 0 {
+		s += fmt.Sprintf("%dS", sec)
+	}
+	return s
+}
+
+// icalText escapes a value of the iCalendar TEXT type
+var icalText = strings.NewReplacer(
+	`\`, `\\`,
+	";", `\;`,
+	",", `\,`,
+	"\r\n", `\n`,
+	"\n", `\n`,
+).Replace
+
+// icalWriter writes the content lines of an iCalendar object,
+// folding them at 75 octets and retaining the first error
+type icalWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (iw *icalWriter) line(s string) {
+	if iw.err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	for limit := 75; len(s) > limit; limit = 74 { // continuations begin with a space
+		// Fold without splitting a UTF-8 sequence
+		n := limit
+		for n > 0 && s[n]&0xC0 == 0x80 {
+			n--
+		}
+		buf.WriteString(s[:n])
+		buf.WriteString("\r\n ")
+		s = s[n:]
+	}
+	buf.WriteString(s)
+	buf.WriteString("\r\n")
+	_, iw.err = iw.w.Write(buf.Bytes())
+}
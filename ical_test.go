@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWriteICal(t *testing.T) {
+	Convey("Given a group in US/Eastern with a Date and a Day", t, func() {
+		g := &Group{ID: "oncall", Name: "On Call, Tier 1", Location: locString}
+		dates := []Date{
+			Date{Group: g.ID, Target: "300", Date: time.Date(2016, 03, 12, 22, 0, 0, 0, loc), Time: 4 * time.Hour},
+		}
+		days := []Day{
+			Day{Group: g.ID, Target: "200", Day: time.Monday, Start: 9 * time.Hour, Duration: 8*time.Hour + 30*time.Minute},
+		}
+		now := time.Date(2016, 03, 16, 12, 0, 0, 0, loc)
+
+		var buf bytes.Buffer
+		So(writeICal(&buf, g, dates, days, now), ShouldBeNil)
+		out := buf.String()
+
+		Convey("The feed should be a calendar with CRLF line endings", func() {
+			So(out, ShouldStartWith, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n")
+			So(out, ShouldEndWith, "END:VCALENDAR\r\n")
+		})
+
+		Convey("The time zone should recur yearly from the 2016 DST transitions", func() {
+			So(out, ShouldContainSubstring, "TZID:US/Eastern\r\n")
+			So(out, ShouldContainSubstring, "BEGIN:DAYLIGHT\r\nDTSTART:20160313T020000\r\nRRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=2SU\r\n"+
+				"TZOFFSETFROM:-0500\r\nTZOFFSETTO:-0400\r\nTZNAME:EDT\r\n")
+			So(out, ShouldContainSubstring, "BEGIN:STANDARD\r\nDTSTART:20161106T020000\r\nRRULE:FREQ=YEARLY;BYMONTH=11;BYDAY=1SU\r\n"+
+				"TZOFFSETFROM:-0400\r\nTZOFFSETTO:-0500\r\nTZNAME:EST\r\n")
+			So(strings.Count(out, "BEGIN:DAYLIGHT"), ShouldEqual, 1)
+		})
+
+		Convey("The Date should be a single event spanning the transition", func() {
+			So(out, ShouldContainSubstring, "DTSTART;TZID=US/Eastern:20160312T220000\r\n")
+			So(out, ShouldContainSubstring, "DTEND;TZID=US/Eastern:20160313T030000\r\n")
+			So(out, ShouldContainSubstring, `SUMMARY:On Call\, Tier 1: 300`)
+		})
+
+		Convey("The Day should be a weekly event starting on its latest instance", func() {
+			So(out, ShouldContainSubstring, "DTSTART;TZID=US/Eastern:20160314T090000\r\n")
+			So(out, ShouldContainSubstring, "DURATION:PT8H30M\r\n")
+			So(out, ShouldContainSubstring, "RRULE:FREQ=WEEKLY;BYDAY=MO\r\n")
+		})
+	})
+
+	Convey("Given a group with a Date under the US DST rules before 2007", t, func() {
+		g := &Group{ID: "old", Location: locString}
+		dates := []Date{
+			Date{Group: g.ID, Target: "300", Date: time.Date(2006, 07, 04, 9, 0, 0, 0, loc), Time: time.Hour},
+		}
+		var buf bytes.Buffer
+		So(writeICal(&buf, g, dates, nil, time.Date(2016, 03, 16, 12, 0, 0, 0, loc)), ShouldBeNil)
+		out := buf.String()
+
+		Convey("The earlier transitions should be listed, without recurring", func() {
+			So(out, ShouldContainSubstring, "BEGIN:DAYLIGHT\r\nDTSTART:20060402T020000\r\nTZOFFSETFROM:-0500\r\n")
+			So(out, ShouldContainSubstring, "BEGIN:STANDARD\r\nDTSTART:20151101T020000\r\nTZOFFSETFROM:-0400\r\n")
+			So(strings.Count(out, "RRULE:FREQ=YEARLY"), ShouldEqual, 2)
+		})
+	})
+
+	Convey("Given a group in Europe/London", t, func() {
+		g := &Group{ID: "london", Location: "Europe/London"}
+		var buf bytes.Buffer
+		So(writeICal(&buf, g, nil, nil, time.Date(2016, 03, 16, 12, 0, 0, 0, time.UTC)), ShouldBeNil)
+
+		Convey("The time zone should recur on the last Sundays of March and October", func() {
+			So(buf.String(), ShouldContainSubstring, "DTSTART:20160327T010000\r\nRRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU\r\n")
+			So(buf.String(), ShouldContainSubstring, "DTSTART:20161030T020000\r\nRRULE:FREQ=YEARLY;BYMONTH=10;BYDAY=-1SU\r\n")
+		})
+	})
+
+	Convey("Given a group in UTC", t, func() {
+		g := &Group{ID: "utc", Location: "UTC"}
+		var buf bytes.Buffer
+		So(writeICal(&buf, g, nil, nil, time.Now()), ShouldBeNil)
+
+		Convey("The time zone should be described by its fixed offset", func() {
+			So(buf.String(), ShouldContainSubstring, "TZOFFSETFROM:+0000\r\nTZOFFSETTO:+0000\r\n")
+		})
+	})
+}
+
+func TestICalWriterFolding(t *testing.T) {
+	Convey("A long content line should be folded at 75 octets", t, func() {
+		var buf bytes.Buffer
+		iw := &icalWriter{w: &buf}
+		iw.line("SUMMARY:" + strings.Repeat("x", 200))
+		So(iw.err, ShouldBeNil)
+
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n")
+		So(len(lines), ShouldBeGreaterThan, 1)
+		for i, l := range lines {
+			So(len(l), ShouldBeLessThanOrEqualTo, 75)
+			if i > 0 {
+				So(l, ShouldStartWith, " ")
+			}
+		}
+		So(strings.Replace(buf.String(), "\r\n ", "", -1), ShouldEqual, "SUMMARY:"+strings.Repeat("x", 200)+"\r\n")
+	})
+}
@@ -0,0 +1,712 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// defaultICalHorizon is the number of days, from the start of today,
+// over which the events of an iCalendar import are expanded
+const defaultICalHorizon = 90
+
+// maxICalHorizon is the longest horizon, in days, which may be requested
+const maxICalHorizon = 366
+
+// maxRecurrencePeriods bounds the expansion of a single recurring
+// event, in periods (days, weeks, months, or years) of its rule
+const maxRecurrencePeriods = 100000
+
+// icalImportOptions are the options of an iCalendar import, in
+// addition to the general import options
+type icalImportOptions struct {
+	// Group is the ID of the group whose schedule is imported
+	Group string
+
+	// Target is the name of the event property which holds the
+	// target (e.g. SUMMARY or LOCATION)
+	Target string
+
+	// Horizon is the number of days over which recurring events
+	// are expanded
+	Horizon int
+}
+
+// parseICalImportOptions parses the iCalendar import options from the
+// query parameters of the request
+func parseICalImportOptions(ctx *echo.Context) (*icalImportOptions, error) {
+	opts := &icalImportOptions{
+		Group:   ctx.Query("group"),
+		Target:  strings.ToUpper(ctx.Query("target")),
+		Horizon: defaultICalHorizon,
+	}
+	if opts.Group == "" {
+		return nil, fmt.Errorf("A group is required")
+	}
+	if opts.Target == "" {
+		opts.Target = "SUMMARY"
+	}
+	if v := ctx.Query("horizon"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxICalHorizon {
+			return nil, fmt.Errorf("Horizon must be a number of days from 1 to %d", maxICalHorizon)
+		}
+		opts.Horizon = n
+	}
+	return opts, nil
+}
+
+// importICal imports the events of an iCalendar file as the Dates of
+// a group.  Each instance of a recurring event within the horizon
+// becomes a Date.  Rows of the result are numbered by event.
+func importICal(ctx *echo.Context, file io.Reader, format string) error {
+	opts, err := parseImportOptions(ctx)
+	if err != nil {
+		return ctx.String(400, err.Error())
+	}
+	iopts, err := parseICalImportOptions(ctx)
+	if err != nil {
+		return ctx.String(400, err.Error())
+	}
+
+	events, err := parseICal(file)
+	if err != nil {
+		return ctx.String(400, err.Error())
+	}
+
+	ret := newImportResult(opts)
+	err = storeFromContext(ctx).Update(func(s Store) error {
+		Log.Debug("Got an iCalendar upload request", "group", iopts.Group, "events", len(events), "mode", opts.Write)
+
+		g, err := s.Group(iopts.Group)
+		if err != nil {
+			return err
+		}
+		loc, err := g.GetLocation()
+		if err != nil {
+			return err
+		}
+
+		from := timeOfLastMidnight(time.Now().In(loc))
+		to := from.AddDate(0, 0, iopts.Horizon)
+
+		var rows []dateRow
+		for i, ev := range events {
+			row := i + 1
+			if strings.ToUpper(ev.value("STATUS")) == "CANCELLED" {
+				ret.addRow(row, g.ID, rowSkippedCancelled, nil)
+				continue
+			}
+			target := strings.TrimSpace(ev.value(iopts.Target))
+			if target == "" {
+				ret.addRow(row, g.ID, rowSkippedEmptyTarget, nil)
+				continue
+			}
+
+			instances, err := ev.instances(events, loc, from, to)
+			if err != nil {
+				Log.Error("Failed to expand event", "uid", ev.value("UID"), "error", err)
+				ret.addRow(row, g.ID, rowError, err)
+				continue
+			}
+			if len(instances) == 0 {
+				ret.addRow(row, g.ID, rowSkippedOutOfRange, nil)
+				continue
+			}
+			for _, in := range instances {
+				rows = append(rows, dateRow{Row: row, Date: &Date{
+					Group:  g.ID,
+					Target: target,
+					Date:   in.Start.In(loc),
+					Time:   in.Stop.Sub(in.Start),
+				}})
+			}
+			ret.addRow(row, g.ID, rowSaved, nil)
+		}
+
+		before := map[string]map[string]interface{}{g.ID: dateSnapshot(s, g)}
+		return saveDates(s, opts, ret, []*Group{g}, before, map[string][]dateRow{g.ID: rows})
+	})
+	if err == ErrNotFound {
+		return ctx.String(404, "Group not found")
+	}
+	return importResponse(ctx, ret, err)
+}
+
+// icalProp is a content line of an iCalendar object
+type icalProp struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// icalEvent is a VEVENT of an iCalendar object, as its properties
+// in the order given
+type icalEvent []icalProp
+
+// prop returns the first property of the event with the given name
+func (ev icalEvent) prop(name string) (icalProp, bool) {
+	for _, p := range ev {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return icalProp{}, false
+}
+
+// value returns the (unescaped) value of the first property of
+// the event with the given name
+func (ev icalEvent) value(name string) string {
+	p, _ := ev.prop(name)
+	return icalUnescape(p.Value)
+}
+
+// parseICal returns the VEVENTs of an iCalendar object.  Components
+// nested within events (e.g. VALARMs) are ignored.
+func parseICal(r io.Reader) ([]icalEvent, error) {
+	var events []icalEvent
+	var stack []string
+	var cur icalEvent
+
+	lines, err := icalLines(r)
+	if err != nil {
+		return nil, err
+	}
+	for n, line := range lines {
+		if line == "" {
+			continue
+		}
+		p, err := parseICalLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse line %d: %s", n+1, err.Error())
+		}
+		switch p.Name {
+		case "BEGIN":
+			stack = append(stack, strings.ToUpper(p.Value))
+			if stack[len(stack)-1] == "VEVENT" {
+				cur = icalEvent{}
+			}
+			continue
+		case "END":
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("Unexpected END:%s on line %d", p.Value, n+1)
+			}
+			if stack[len(stack)-1] == "VEVENT" {
+				events = append(events, cur)
+			}
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		if len(stack) > 0 && stack[len(stack)-1] == "VEVENT" {
+			cur = append(cur, p)
+		}
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("Unterminated %s", stack[len(stack)-1])
+	}
+	if len(events) == 0 && len(lines) > 0 && !strings.HasPrefix(strings.ToUpper(lines[0]), "BEGIN:VCALENDAR") {
+		return nil, fmt.Errorf("Not an iCalendar file")
+	}
+	return events, nil
+}
+
+// icalLines returns the unfolded content lines of an iCalendar object
+func icalLines(r io.Reader) (lines []string, err error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	for s.Scan() {
+		line := strings.TrimRight(s.Text(), "\r")
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, s.Err()
+}
+
+// parseICalLine parses a content line of the form
+// NAME;PARAM=VALUE;...:VALUE
+func parseICalLine(line string) (p icalProp, err error) {
+	p.Params = make(map[string]string)
+
+	// Split at the first colon outside of a quoted parameter value
+	quoted := false
+	sep := -1
+	for i := 0; i < len(line) && sep < 0; i++ {
+		switch line[i] {
+		case '"':
+			quoted = !quoted
+		case ':':
+			if !quoted {
+				sep = i
+			}
+		}
+	}
+	if sep < 0 {
+		return p, fmt.Errorf("Missing value")
+	}
+	p.Value = line[sep+1:]
+
+	head := splitUnquoted(line[:sep], ';')
+	p.Name = strings.ToUpper(head[0])
+	for _, param := range head[1:] {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 {
+			return p, fmt.Errorf("Malformed parameter %s", param)
+		}
+		p.Params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], `"`)
+	}
+	return p, nil
+}
+
+// splitUnquoted splits s at each separator outside of double quotes
+func splitUnquoted(s string, sep byte) (ret []string) {
+	quoted := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				ret = append(ret, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(ret, s[start:])
+}
+
+// icalUnescape unescapes a value of the iCalendar TEXT type
+var icalUnescape = strings.NewReplacer(
+	`\\`, `\`,
+	`\;`, ";",
+	`\,`, ",",
+	`\n`, "\n",
+	`\N`, "\n",
+).Replace
+
+// parseICalTime parses a DATE or DATE-TIME property.  Floating times,
+// and times in unknown time zones, are taken to be in `loc`.
+func parseICalTime(p icalProp, loc *time.Location) (t time.Time, allDay bool, err error) {
+	v := strings.TrimSpace(p.Value)
+	if tzid := strings.TrimPrefix(p.Params["TZID"], "/"); tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		} else {
+			Log.Warn("Unknown time zone; using the group's", "tzid", tzid)
+		}
+	}
+
+	switch {
+	case p.Params["VALUE"] == "DATE" || len(v) == 8:
+		t, err = time.ParseInLocation("20060102", v, loc)
+		return t, true, err
+	case strings.HasSuffix(v, "Z"):
+		t, err = time.Parse(icalUTCTime, v)
+	default:
+		t, err = time.ParseInLocation(icalLocalTime, v, loc)
+	}
+	return t, false, err
+}
+
+// parseICalDuration parses an iCalendar DURATION value, e.g. PT1H30M.
+// Days and weeks are taken to be exactly 24 hours and 7 days long.
+func parseICalDuration(v string) (time.Duration, error) {
+	src := v
+	neg := false
+	switch {
+	case strings.HasPrefix(v, "-"):
+		neg = true
+		v = v[1:]
+	case strings.HasPrefix(v, "+"):
+		v = v[1:]
+	}
+	if !strings.HasPrefix(v, "P") {
+		return 0, fmt.Errorf("Malformed duration %s", src)
+	}
+	v = v[1:]
+
+	var d time.Duration
+	inTime := false
+	num := ""
+	for _, c := range v {
+		switch {
+		case c >= '0' && c <= '9':
+			num += string(c)
+			continue
+		case c == 'T':
+			inTime = true
+			continue
+		}
+		n, err := strconv.Atoi(num)
+		if err != nil {
+			return 0, fmt.Errorf("Malformed duration %s", src)
+		}
+		num = ""
+		switch {
+		case c == 'W' && !inTime:
+			d += time.Duration(n) * 7 * 24 * time.Hour
+		case c == 'D' && !inTime:
+			d += time.Duration(n) * 24 * time.Hour
+		case c == 'H' && inTime:
+			d += time.Duration(n) * time.Hour
+		case c == 'M' && inTime:
+			d += time.Duration(n) * time.Minute
+		case c == 'S' && inTime:
+			d += time.Duration(n) * time.Second
+		default:
+			return 0, fmt.Errorf("Malformed duration %s", src)
+		}
+	}
+	if num != "" {
+		return 0, fmt.Errorf("Malformed duration %s", src)
+	}
+	if neg {
+		d = -d
+	}
+	return d, nil
+}
+
+// instances returns the instances of the event which overlap the
+// given range, expanding its recurrence rule, if any.  Instances which
+// are excluded (EXDATE) or overridden by another event of the same
+// UID (RECURRENCE-ID) are omitted.
+func (ev icalEvent) instances(all []icalEvent, loc *time.Location, from, to time.Time) ([]Interval, error) {
+	p, ok := ev.prop("DTSTART")
+	if !ok {
+		return nil, fmt.Errorf("Event has no DTSTART")
+	}
+	start, allDay, err := parseICalTime(p, loc)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse DTSTART: %s", err.Error())
+	}
+
+	// Determine the length of each instance
+	var days int
+	var length time.Duration
+	if p, ok := ev.prop("DTEND"); ok {
+		end, _, err := parseICalTime(p, loc)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse DTEND: %s", err.Error())
+		}
+		if allDay {
+			days = int(end.Sub(start).Hours()/24 + 0.5)
+		} else {
+			length = end.Sub(start)
+		}
+	} else if p, ok := ev.prop("DURATION"); ok {
+		if length, err = parseICalDuration(p.Value); err != nil {
+			return nil, err
+		}
+		if allDay {
+			days, length = int(length/(24*time.Hour)), 0
+		}
+	} else if allDay {
+		days = 1
+	}
+	if days <= 0 && length <= 0 {
+		return nil, fmt.Errorf("Event has no duration")
+	}
+	stopOf := func(t time.Time) time.Time {
+		if days > 0 {
+			return t.AddDate(0, 0, days)
+		}
+		return t.Add(length)
+	}
+
+	// A single event, or an override of one instance
+	rrule, ok := ev.prop("RRULE")
+	if !ok || ev.value("RECURRENCE-ID") != "" {
+		if stopOf(start).After(from) && start.Before(to) {
+			return []Interval{{Start: start, Stop: stopOf(start)}}, nil
+		}
+		return nil, nil
+	}
+
+	// Collect the excluded instances.  An excluded date (rather
+	// than date-time) excludes any instance on that day.
+	var excluded []time.Time
+	excludedDays := make(map[string]bool)
+	for _, p := range ev {
+		if p.Name != "EXDATE" {
+			continue
+		}
+		for _, v := range strings.Split(p.Value, ",") {
+			t, exAllDay, err := parseICalTime(icalProp{Params: p.Params, Value: v}, start.Location())
+			if err != nil {
+				return nil, fmt.Errorf("Failed to parse EXDATE: %s", err.Error())
+			}
+			if exAllDay {
+				excludedDays[t.Format("20060102")] = true
+				continue
+			}
+			excluded = append(excluded, t)
+		}
+	}
+	uid := ev.value("UID")
+	for _, o := range all {
+		if uid == "" || o.value("UID") != uid {
+			continue
+		}
+		if p, ok := o.prop("RECURRENCE-ID"); ok {
+			t, _, err := parseICalTime(p, start.Location())
+			if err != nil {
+				return nil, fmt.Errorf("Failed to parse RECURRENCE-ID: %s", err.Error())
+			}
+			excluded = append(excluded, t)
+		}
+	}
+
+	starts, err := expandRRule(rrule.Value, start, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []Interval
+	for _, s := range starts {
+		skip := excludedDays[s.Format("20060102")]
+		for _, x := range excluded {
+			if s.Equal(x) {
+				skip = true
+				break
+			}
+		}
+		if !skip && stopOf(s).After(from) {
+			ret = append(ret, Interval{Start: s, Stop: stopOf(s)})
+		}
+	}
+	return ret, nil
+}
+
+// expandRRule returns the start of every instance, before `to`, of
+// the given recurrence rule with the given first instance.  The
+// instances keep the wall-clock time of the first.  FREQ of DAILY,
+// WEEKLY, MONTHLY, or YEARLY is supported, along with INTERVAL, COUNT,
+// UNTIL, WKST, BYDAY, and (for MONTHLY) BYMONTHDAY.
+func expandRRule(rule string, start, to time.Time) ([]time.Time, error) {
+	parts := make(map[string]string)
+	for _, part := range strings.Split(rule, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("Malformed RRULE part %s", part)
+		}
+		parts[strings.ToUpper(kv[0])] = strings.ToUpper(kv[1])
+	}
+
+	interval := 1
+	count := 0
+	until := to
+	wkst := time.Monday
+	var byDay []icalByDay
+	var byMonthDay []int
+	for k, v := range parts {
+		var err error
+		switch k {
+		case "FREQ":
+		case "INTERVAL":
+			if interval, err = strconv.Atoi(v); err != nil || interval < 1 {
+				return nil, fmt.Errorf("Malformed RRULE INTERVAL %s", v)
+			}
+		case "COUNT":
+			if count, err = strconv.Atoi(v); err != nil || count < 1 {
+				return nil, fmt.Errorf("Malformed RRULE COUNT %s", v)
+			}
+		case "UNTIL":
+			u, allDay, err := parseICalTime(icalProp{Value: v}, start.Location())
+			if err != nil {
+				return nil, fmt.Errorf("Malformed RRULE UNTIL %s", v)
+			}
+			if allDay {
+				u = u.AddDate(0, 0, 1).Add(-time.Nanosecond)
+			}
+			if u.Before(until) {
+				until = u.Add(time.Nanosecond)
+			}
+		case "WKST":
+			if wkst, err = icalWeekday(v); err != nil {
+				return nil, err
+			}
+		case "BYDAY":
+			for _, d := range strings.Split(v, ",") {
+				bd, err := parseICalByDay(d)
+				if err != nil {
+					return nil, err
+				}
+				byDay = append(byDay, bd)
+			}
+		case "BYMONTHDAY":
+			for _, d := range strings.Split(v, ",") {
+				n, err := strconv.Atoi(d)
+				if err != nil || n == 0 || n < -31 || n > 31 {
+					return nil, fmt.Errorf("Malformed RRULE BYMONTHDAY %s", d)
+				}
+				byMonthDay = append(byMonthDay, n)
+			}
+		default:
+			return nil, fmt.Errorf("Unsupported RRULE part %s", k)
+		}
+	}
+
+	// at returns the given day at the wall-clock time of the first instance
+	at := func(t time.Time) time.Time {
+		y, m, d := t.Date()
+		return time.Date(y, m, d, start.Hour(), start.Minute(), start.Second(), 0, start.Location())
+	}
+	first := at(start)
+
+	var candidates func(period int) []time.Time
+	switch parts["FREQ"] {
+	case "DAILY":
+		candidates = func(period int) []time.Time {
+			t := at(first.AddDate(0, 0, period*interval))
+			if len(byDay) > 0 && !matchesByDay(byDay, t) {
+				return nil
+			}
+			return []time.Time{t}
+		}
+	case "WEEKLY":
+		if len(byDay) == 0 {
+			byDay = []icalByDay{{Weekday: start.Weekday()}}
+		}
+		weekStart := first.AddDate(0, 0, -int((first.Weekday()-wkst+7)%7))
+		candidates = func(period int) (ret []time.Time) {
+			ws := weekStart.AddDate(0, 0, 7*period*interval)
+			for i := 0; i < 7; i++ {
+				if t := at(ws.AddDate(0, 0, i)); matchesByDay(byDay, t) {
+					ret = append(ret, t)
+				}
+			}
+			return
+		}
+	case "MONTHLY":
+		if len(byDay) == 0 && len(byMonthDay) == 0 {
+			byMonthDay = []int{start.Day()}
+		}
+		candidates = func(period int) (ret []time.Time) {
+			ms := time.Date(first.Year(), first.Month()+time.Month(period*interval), 1, 0, 0, 0, 0, first.Location())
+			n := ms.AddDate(0, 1, -1).Day()
+			for d := 1; d <= n; d++ {
+				t := at(ms.AddDate(0, 0, d-1))
+				if len(byMonthDay) > 0 && !matchesMonthDay(byMonthDay, d, n) {
+					continue
+				}
+				if len(byDay) > 0 && !matchesByDayInMonth(byDay, t, n) {
+					continue
+				}
+				ret = append(ret, t)
+			}
+			return
+		}
+	case "YEARLY":
+		if len(byDay) > 0 || len(byMonthDay) > 0 {
+			return nil, fmt.Errorf("Unsupported RRULE: YEARLY with BYDAY or BYMONTHDAY")
+		}
+		candidates = func(period int) []time.Time {
+			y := first.Year() + period*interval
+			t := time.Date(y, first.Month(), first.Day(), start.Hour(), start.Minute(), start.Second(), 0, start.Location())
+			if t.Day() != first.Day() {
+				return nil // e.g. February 29 of a common year
+			}
+			return []time.Time{t}
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported RRULE FREQ %s", parts["FREQ"])
+	}
+
+	var ret []time.Time
+	n := 0
+	for period := 0; period < maxRecurrencePeriods; period++ {
+		list := candidates(period)
+		sort.Sort(byTime(list))
+		for _, t := range list {
+			if t.Before(start) {
+				continue
+			}
+			if !t.Before(until) || (count > 0 && n >= count) {
+				return ret, nil
+			}
+			ret = append(ret, t)
+			n++
+		}
+	}
+	return ret, nil
+}
+
+// icalByDay is an element of a BYDAY rule part, e.g. MO or -1FR
+type icalByDay struct {
+	Ordinal int // 0 for every such weekday of the period
+	Weekday time.Weekday
+}
+
+func parseICalByDay(v string) (bd icalByDay, err error) {
+	if len(v) < 2 {
+		return bd, fmt.Errorf("Malformed RRULE BYDAY %s", v)
+	}
+	if bd.Weekday, err = icalWeekday(v[len(v)-2:]); err != nil {
+		return
+	}
+	if n := v[:len(v)-2]; n != "" {
+		if bd.Ordinal, err = strconv.Atoi(n); err != nil || bd.Ordinal == 0 {
+			return bd, fmt.Errorf("Malformed RRULE BYDAY %s", v)
+		}
+	}
+	return
+}
+
+func icalWeekday(v string) (time.Weekday, error) {
+	for i, d := range icalWeekdays {
+		if d == v {
+			return time.Weekday(i), nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown weekday %s", v)
+}
+
+// matchesByDay says whether t falls on any of the given weekdays,
+// ignoring ordinals
+func matchesByDay(byDay []icalByDay, t time.Time) bool {
+	for _, bd := range byDay {
+		if bd.Weekday == t.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesByDayInMonth says whether t falls on any of the given
+// weekdays, with ordinals counted within its month of n days
+func matchesByDayInMonth(byDay []icalByDay, t time.Time, n int) bool {
+	for _, bd := range byDay {
+		if bd.Weekday != t.Weekday() {
+			continue
+		}
+		switch {
+		case bd.Ordinal == 0:
+			return true
+		case bd.Ordinal > 0 && (t.Day()-1)/7+1 == bd.Ordinal:
+			return true
+		case bd.Ordinal < 0 && (n-t.Day())/7+1 == -bd.Ordinal:
+			return true
+		}
+	}
+	return false
+}
+
+// matchesMonthDay says whether day d of a month of n days is any of
+// the given (possibly negative) days of the month
+func matchesMonthDay(byMonthDay []int, d, n int) bool {
+	for _, md := range byMonthDay {
+		if md == d || (md < 0 && n+md+1 == d) {
+			return true
+		}
+	}
+	return false
+}
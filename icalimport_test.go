@@ -0,0 +1,181 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var testICal = strings.Replace(`BEGIN:VCALENDAR
+VERSION:2.0
+PRODID:-//Test//Test//EN
+BEGIN:VEVENT
+UID:weekly@test
+DTSTART;TZID=America/New_York:20160307T090000
+DTEND;TZID=America/New_York:20160307T170000
+RRULE:FREQ=WEEKLY;BYDAY=MO,WE;COUNT=6
+EXDATE;TZID=America/New_York:20160309T090000
+SUMMARY:Alice\, primary
+LOCATION:5551234
+BEGIN:VALARM
+ACTION:DISPLAY
+SUMMARY:Reminder
+END:VALARM
+END:VEVENT
+BEGIN:VEVENT
+UID:weekly@test
+RECURRENCE-ID;TZID=America/New_York:20160314T090000
+DTSTART;TZID=America/New_York:20160314T100000
+DTEND;TZID=America/New_York:20160314T180000
+SUMMARY:Bob
+END:VEVENT
+BEGIN:VEVENT
+UID:allday@test
+DTSTART;VALUE=DATE:20160312
+DTEND;VALUE=DATE:20160314
+DESCRIPTION:A long description which is folded across
+  two lines
+SUMMARY:Carol
+END:VEVENT
+END:VCALENDAR
+`, "\n", "\r\n", -1)
+
+func TestParseICal(t *testing.T) {
+	Convey("Given an iCalendar file with three events", t, func() {
+		events, err := parseICal(strings.NewReader(testICal))
+		So(err, ShouldBeNil)
+		So(events, ShouldHaveLength, 3)
+
+		Convey("Properties of nested components should be ignored", func() {
+			So(events[0].value("SUMMARY"), ShouldEqual, "Alice, primary")
+		})
+
+		Convey("Parameters should be parsed", func() {
+			p, ok := events[0].prop("DTSTART")
+			So(ok, ShouldBeTrue)
+			So(p.Params["TZID"], ShouldEqual, "America/New_York")
+			So(p.Value, ShouldEqual, "20160307T090000")
+		})
+
+		Convey("Folded lines should be unfolded", func() {
+			So(events[2].value("DESCRIPTION"), ShouldEqual, "A long description which is folded across two lines")
+		})
+	})
+
+	Convey("A file which is not an iCalendar should be rejected", t, func() {
+		_, err := parseICal(strings.NewReader("group,day,start,stop,target\n"))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestParseICalDuration(t *testing.T) {
+	Convey("iCalendar durations should be parsed", t, func() {
+		d, err := parseICalDuration("PT1H30M")
+		So(err, ShouldBeNil)
+		So(d, ShouldEqual, 90*time.Minute)
+
+		d, err = parseICalDuration("P1DT2H")
+		So(err, ShouldBeNil)
+		So(d, ShouldEqual, 26*time.Hour)
+
+		d, err = parseICalDuration("-P1W")
+		So(err, ShouldBeNil)
+		So(d, ShouldEqual, -7*24*time.Hour)
+
+		_, err = parseICalDuration("PT1")
+		So(err, ShouldNotBeNil)
+		_, err = parseICalDuration("1H")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestExpandRRule(t *testing.T) {
+	ny, _ := time.LoadLocation("America/New_York")
+	start := time.Date(2016, 03, 7, 9, 0, 0, 0, ny) // a Monday, the week before DST begins
+	to := start.AddDate(1, 0, 0)
+
+	Convey("A weekly rule should keep its wall-clock time across DST", t, func() {
+		list, err := expandRRule("FREQ=WEEKLY;COUNT=3", start, to)
+		So(err, ShouldBeNil)
+		So(list, ShouldHaveLength, 3)
+		for i, t := range list {
+			So(t.Weekday(), ShouldEqual, time.Monday)
+			So(t.Hour(), ShouldEqual, 9)
+			So(t.Day(), ShouldEqual, 7+7*i)
+		}
+	})
+
+	Convey("A rule with UNTIL should include its last instance", t, func() {
+		list, err := expandRRule("FREQ=DAILY;INTERVAL=2;UNTIL=20160311T140000Z", start, to)
+		So(err, ShouldBeNil)
+		So(list, ShouldHaveLength, 3) // the 7th, 9th, and 11th
+	})
+
+	Convey("A monthly rule should support ordinal weekdays", t, func() {
+		list, err := expandRRule("FREQ=MONTHLY;BYDAY=-1FR;COUNT=2", start, to)
+		So(err, ShouldBeNil)
+		So(list, ShouldHaveLength, 2)
+		So(list[0].Format("2006-01-02 15:04"), ShouldEqual, "2016-03-25 09:00")
+		So(list[1].Format("2006-01-02 15:04"), ShouldEqual, "2016-04-29 09:00")
+	})
+
+	Convey("A monthly rule should skip months without its day", t, func() {
+		list, err := expandRRule("FREQ=MONTHLY;BYMONTHDAY=31;COUNT=3", start, to)
+		So(err, ShouldBeNil)
+		So(list, ShouldHaveLength, 3)
+		So(list[1].Month(), ShouldEqual, time.May)
+	})
+
+	Convey("Unsupported rules should be reported", t, func() {
+		_, err := expandRRule("FREQ=HOURLY", start, to)
+		So(err, ShouldNotBeNil)
+		_, err = expandRRule("FREQ=WEEKLY;BYSETPOS=1", start, to)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestICalEventInstances(t *testing.T) {
+	Convey("Given the events of an iCalendar file", t, func() {
+		events, err := parseICal(strings.NewReader(testICal))
+		So(err, ShouldBeNil)
+		from := time.Date(2016, 03, 1, 0, 0, 0, 0, loc)
+		to := from.AddDate(0, 1, 0)
+
+		Convey("A recurring event should omit excluded and overridden instances", func() {
+			list, err := events[0].instances(events, loc, from, to)
+			So(err, ShouldBeNil)
+
+			// Of Mar 7, 9, 14, 16, 21, and 23, the 9th is excluded and the 14th overridden
+			So(list, ShouldHaveLength, 4)
+			var days []int
+			for _, in := range list {
+				days = append(days, in.Start.Day())
+				So(in.Stop.Sub(in.Start), ShouldEqual, 8*time.Hour)
+			}
+			So(days, ShouldResemble, []int{7, 16, 21, 23})
+		})
+
+		Convey("An override should be a single instance", func() {
+			list, err := events[1].instances(events, loc, from, to)
+			So(err, ShouldBeNil)
+			So(list, ShouldHaveLength, 1)
+			So(list[0].Start.Hour(), ShouldEqual, 10)
+		})
+
+		Convey("An all-day event should span whole days in the group's time zone", func() {
+			list, err := events[2].instances(events, loc, from, to)
+			So(err, ShouldBeNil)
+			So(list, ShouldHaveLength, 1)
+			So(list[0].Start.Equal(time.Date(2016, 03, 12, 0, 0, 0, 0, loc)), ShouldBeTrue)
+			So(list[0].Stop.Sub(list[0].Start), ShouldEqual, 47*time.Hour) // across the DST transition
+		})
+
+		Convey("Instances outside of the range should be omitted", func() {
+			list, err := events[2].instances(events, loc, to, to.AddDate(0, 1, 0))
+			So(err, ShouldBeNil)
+			So(list, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,808 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// Overlap policies for imports, selected by the `mode` parameter
+const (
+	overlapWarn   = "warn"   // accept overlapping rows, returning warnings
+	overlapStrict = "strict" // reject an upload with overlapping rows
+)
+
+// Write strategies for imports, selected by the `mode` parameter
+const (
+	writeReplace      = "replace"       // replace the whole schedule of each group in the upload
+	writeMerge        = "merge"         // add or update (by key) entries, keeping all others
+	writeReplaceRange = "replace-range" // replace only the entries within the span of the upload
+)
+
+// Row outcomes of an import
+const (
+	rowSaved              = "saved"                // the row was (or, for a dry run, would be) saved
+	rowSkippedEmptyTarget = "skipped-empty-target" // the row was ignored because it has no target
+	rowSkippedOutOfRange  = "skipped-out-of-range" // the (calendar) event has no instance within the import horizon
+	rowSkippedCancelled   = "skipped-cancelled"    // the (calendar) event was cancelled
+	rowHeader             = "header"               // the (first) row was ignored as a header
+	rowError              = "error"                // the row could not be parsed or saved
+	rowRejected           = "rejected"             // the row was valid, but the import was rejected
+)
+
+// errImportRejected indicates that an import was rejected
+// (and its transaction rolled back) due to row errors
+var errImportRejected = errors.New("Import rejected")
+
+// errDryRun indicates that an import was a dry run, and its
+// transaction should be rolled back
+var errDryRun = errors.New("Dry run")
+
+// ImportResult is the response to a schedule import
+type ImportResult struct {
+	// Coverage is the coverage report, over the coming week, of each
+	// group whose schedule was imported
+	Coverage []*CoverageReport `json:"coverage"`
+
+	// Warnings lists problems with rows which were nevertheless imported
+	Warnings []RowIssue `json:"warnings"`
+
+	// Errors lists problems with rows which caused the import to be rejected
+	Errors []RowIssue `json:"errors"`
+
+	// DryRun indicates that the import was not saved
+	DryRun bool `json:"dryRun"`
+
+	// Changes lists the changes which the import makes (or, for a
+	// dry run, would make) to the schedule of each group
+	Changes []GroupDiff `json:"changes"`
+
+	// Rows reports the outcome of each row of the upload
+	Rows []RowResult `json:"rows"`
+
+	// Groups counts the row outcomes of each group in the upload
+	Groups map[string]*GroupCounts `json:"groups"`
+}
+
+// RowResult is the outcome of a single row of an import
+type RowResult struct {
+	Row    int    `json:"row"`             // Row number, starting at 1
+	Group  string `json:"group"`           // Group ID of the row, if known
+	Status string `json:"status"`          // saved, skipped-*, header, error, or rejected
+	Error  string `json:"error,omitempty"` // Reason the row could not be imported
+}
+
+// GroupCounts counts the row outcomes of a group in an import
+type GroupCounts struct {
+	Saved    int `json:"saved"`
+	Skipped  int `json:"skipped"`
+	Errors   int `json:"errors"`
+	Rejected int `json:"rejected"`
+}
+
+// addRow records the outcome of a row of the upload.  Rows with
+// errors cause the import to be rejected.
+func (r *ImportResult) addRow(row int, group, status string, err error) {
+	res := RowResult{
+		Row:    row,
+		Group:  group,
+		Status: status,
+	}
+	if err != nil {
+		res.Error = err.Error()
+		r.Errors = append(r.Errors, RowIssue{Row: row, Group: group, Message: res.Error})
+	}
+	r.Rows = append(r.Rows, res)
+
+	if group == "" {
+		return
+	}
+	c, ok := r.Groups[group]
+	if !ok {
+		c = new(GroupCounts)
+		r.Groups[group] = c
+	}
+	switch status {
+	case rowSaved:
+		c.Saved++
+	case rowSkippedEmptyTarget, rowSkippedOutOfRange, rowSkippedCancelled:
+		c.Skipped++
+	case rowError:
+		c.Errors++
+	}
+}
+
+// reject records that the import was rejected:  the rows which
+// would have been saved are not
+func (r *ImportResult) reject() {
+	for i := range r.Rows {
+		if r.Rows[i].Status == rowSaved {
+			r.Rows[i].Status = rowRejected
+		}
+	}
+	for _, c := range r.Groups {
+		c.Rejected += c.Saved
+		c.Saved = 0
+	}
+}
+
+// readCSV reads the rows of a schedule CSV with the given fields,
+// locating the fields of each row by header, column mapping, or
+// position (see newCSVColumns).  The fields of each data row are
+// passed to fn, along with the row number and whether the row, being
+// the first of a positional CSV, may be a header.  Header rows and
+// unreadable rows are recorded in ret.  An error returned by fn ends
+// the read.
+func readCSV(file io.Reader, fields []string, opts *importOptions, ret *ImportResult, fn func(row int, rec []string, guessHeader bool) error) error {
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1 // column counts are checked by the row parsers
+
+	var cols *csvColumns
+	var row int
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		row++
+		if err != nil {
+			Log.Error("Failed to read CSV row", "row", row, "error", err)
+			ret.addRow(row, "", rowError, err)
+			if _, ok := err.(*csv.ParseError); ok {
+				continue
+			}
+			return nil
+		}
+
+		// Locate the columns, using the first row
+		if cols == nil {
+			var isHeader bool
+			cols, isHeader, err = newCSVColumns(fields, opts.Columns, rec)
+			if err != nil {
+				ret.addRow(row, "", rowError, err)
+				return nil
+			}
+			if isHeader {
+				ret.addRow(row, "", rowHeader, nil)
+				continue
+			}
+		}
+		rec, err = cols.row(rec)
+		if err != nil {
+			ret.addRow(row, "", rowError, err)
+			continue
+		}
+
+		if err = fn(row, rec, row == 1 && cols.index == nil); err != nil {
+			return err
+		}
+	}
+}
+
+// csvGroup returns the group ID column of a CSV row, if present
+func csvGroup(rec []string) string {
+	if len(rec) < 1 {
+		return ""
+	}
+	return rec[0]
+}
+
+// GroupDiff describes the changes an import makes to the
+// schedule of a group
+type GroupDiff struct {
+	Group   string        `json:"group"`
+	Added   []EntryChange `json:"added"`
+	Removed []EntryChange `json:"removed"`
+	Changed []EntryChange `json:"changed"`
+}
+
+// EntryChange describes the change to a single schedule entry,
+// in its external (DayExternal or DateExternal) form
+type EntryChange struct {
+	Key    string      `json:"key"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// RowIssue describes a problem with a row of an import
+type RowIssue struct {
+	Row     int    `json:"row"`     // Row number, starting at 1
+	Group   string `json:"group"`   // Group ID of the row
+	Message string `json:"message"` // Description of the problem
+}
+
+// importOptions are the options of an import, parsed from its
+// query parameters
+type importOptions struct {
+	// Overlap is the policy for overlapping rows (warn or strict)
+	Overlap string
+
+	// Write is the write strategy (replace, merge, or replace-range)
+	Write string
+
+	// DryRun, when set, validates the import and reports its
+	// changes without saving them
+	DryRun bool
+
+	// Columns is the optional mapping of CSV fields to columns
+	// (see newCSVColumns)
+	Columns string
+}
+
+// parseImportOptions parses the import options from the query
+// parameters of the request.  `mode` may be given as a
+// comma-separated list of options, e.g. `mode=merge,strict`.
+func parseImportOptions(ctx *echo.Context) (*importOptions, error) {
+	opts := &importOptions{
+		Overlap: overlapWarn,
+		Write:   writeReplace,
+		Columns: ctx.Query("columns"),
+	}
+	for _, m := range strings.Split(ctx.Query("mode"), ",") {
+		switch m = strings.TrimSpace(strings.ToLower(m)); m {
+		case "":
+		case overlapWarn, overlapStrict:
+			opts.Overlap = m
+		case writeReplace, writeMerge, writeReplaceRange:
+			opts.Write = m
+		default:
+			return nil, fmt.Errorf("Unknown import mode %s", m)
+		}
+	}
+	switch strings.ToLower(ctx.Query("dryRun")) {
+	case "", "0", "false":
+	case "1", "true":
+		opts.DryRun = true
+	default:
+		return nil, fmt.Errorf("Invalid dryRun value %s", ctx.Query("dryRun"))
+	}
+	return opts, nil
+}
+
+// daySnapshot returns the external form of each Day of the
+// group, as held by the given Store, by key
+func daySnapshot(s Store, g *Group) map[string]interface{} {
+	ret := make(map[string]interface{})
+	days, _ := s.Days(g)
+	for _, d := range days {
+		ret[string(d.Key())] = *d.ToExternal()
+	}
+	return ret
+}
+
+// dateSnapshot returns the external form of each Date of the
+// group, as held by the given Store, by key
+func dateSnapshot(s Store, g *Group) map[string]interface{} {
+	ret := make(map[string]interface{})
+	dates, _ := s.Dates(g)
+	for _, d := range dates {
+		ret[string(d.Key())] = *d.ToExternal()
+	}
+	return ret
+}
+
+// diffEntries compares two snapshots of a group's schedule
+func diffEntries(group string, before, after map[string]interface{}) GroupDiff {
+	diff := GroupDiff{
+		Group:   group,
+		Added:   []EntryChange{},
+		Removed: []EntryChange{},
+		Changed: []EntryChange{},
+	}
+
+	var keys []string
+	for k := range before {
+		keys = append(keys, k)
+	}
+	for k := range after {
+		if _, ok := before[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		b, inBefore := before[k]
+		a, inAfter := after[k]
+		switch {
+		case !inBefore:
+			diff.Added = append(diff.Added, EntryChange{Key: k, After: a})
+		case !inAfter:
+			diff.Removed = append(diff.Removed, EntryChange{Key: k, Before: b})
+		case a != b:
+			diff.Changed = append(diff.Changed, EntryChange{Key: k, Before: b, After: a})
+		}
+	}
+	return diff
+}
+
+// dayRow is a Day parsed from a row of an import
+type dayRow struct {
+	Row int
+	Day *Day
+}
+
+// dateRow is a Date parsed from a row of an import
+type dateRow struct {
+	Row  int
+	Date *Date
+}
+
+// dayOverlaps reports each row which overlaps an earlier row of
+// the same group
+func dayOverlaps(rows []dayRow) (issues []RowIssue) {
+	for i, r := range rows {
+		for _, prev := range rows[:i] {
+			if prev.Day.Group != r.Day.Group {
+				continue
+			}
+			if bytes.Equal(prev.Day.Key(), r.Day.Key()) {
+				issues = append(issues, RowIssue{
+					Row:     r.Row,
+					Group:   r.Day.Group,
+					Message: fmt.Sprintf("Same day and start time as row %d, which it replaces", prev.Row),
+				})
+			} else if prev.Day.Overlaps(r.Day) {
+				issues = append(issues, RowIssue{
+					Row:     r.Row,
+					Group:   r.Day.Group,
+					Message: fmt.Sprintf("Overlaps row %d", prev.Row),
+				})
+			}
+		}
+	}
+	return
+}
+
+// dateOverlaps reports each row which overlaps an earlier row of
+// the same group
+func dateOverlaps(rows []dateRow) (issues []RowIssue) {
+	for i, r := range rows {
+		for _, prev := range rows[:i] {
+			if prev.Date.Group != r.Date.Group {
+				continue
+			}
+			if bytes.Equal(prev.Date.Key(), r.Date.Key()) {
+				issues = append(issues, RowIssue{
+					Row:     r.Row,
+					Group:   r.Date.Group,
+					Message: fmt.Sprintf("Same start as row %d, which it replaces", prev.Row),
+				})
+			} else if prev.Date.Overlaps(r.Date) {
+				issues = append(issues, RowIssue{
+					Row:     r.Row,
+					Group:   r.Date.Group,
+					Message: fmt.Sprintf("Overlaps row %d", prev.Row),
+				})
+			}
+		}
+	}
+	return
+}
+
+// dayConflicts reports each row which overlaps an entry of the
+// group's schedule which did not come from the upload
+func dayConflicts(rows []dayRow, existing []Day) (issues []RowIssue) {
+	uploaded := make(map[string]bool)
+	for _, r := range rows {
+		uploaded[string(r.Day.Key())] = true
+	}
+	for _, r := range rows {
+		for i := range existing {
+			e := &existing[i]
+			if uploaded[string(e.Key())] || !r.Day.Overlaps(e) {
+				continue
+			}
+			issues = append(issues, RowIssue{
+				Row:     r.Row,
+				Group:   r.Day.Group,
+				Message: fmt.Sprintf("Overlaps existing entry %s", e.Key()),
+			})
+		}
+	}
+	return
+}
+
+// dateConflicts reports each row which overlaps an entry of the
+// group's schedule which did not come from the upload
+func dateConflicts(rows []dateRow, existing []Date) (issues []RowIssue) {
+	uploaded := make(map[string]bool)
+	for _, r := range rows {
+		uploaded[string(r.Date.Key())] = true
+	}
+	for _, r := range rows {
+		for i := range existing {
+			e := &existing[i]
+			if uploaded[string(e.Key())] || !r.Date.Overlaps(e) {
+				continue
+			}
+			issues = append(issues, RowIssue{
+				Row:     r.Row,
+				Group:   r.Date.Group,
+				Message: fmt.Sprintf("Overlaps existing entry %s", e.Key()),
+			})
+		}
+	}
+	return
+}
+
+// fileHandler reads the schedule uploaded as either the request body
+// (see bodyFormats) or the multipart `file` (see fileFormats), and
+// passes it, along with its format, to fn
+func fileHandler(fn func(ctx *echo.Context, r io.Reader, format string) error) func(ctx *echo.Context) error {
+	return func(ctx *echo.Context) error {
+		// Parse the attached file
+		req := ctx.Request()
+
+		var input io.Reader
+		format := formatCSV
+
+		if h, ok := req.Header["Content-Type"]; ok {
+			if f, ok := bodyFormats[mediaType(h[0])]; ok {
+				i := req.Body
+				defer i.Close()
+
+				input = i
+				format = f
+			} else {
+				i, fh, err := req.FormFile("file")
+				if err != nil {
+					return err
+				}
+				defer i.Close()
+
+				input = i
+				format = fileFormat(fh.Filename)
+			}
+		}
+
+		return fn(ctx, input, format)
+	}
+}
+
+func importDates(ctx *echo.Context, file io.Reader, format string) error {
+	opts, err := parseImportOptions(ctx)
+	if err != nil {
+		return ctx.String(400, err.Error())
+	}
+
+	ret := newImportResult(opts)
+	err = storeFromContext(ctx).Update(func(s Store) error {
+		Log.Debug("Got a Dates upload request", "mode", opts.Write)
+
+		var groups []*Group
+		before := make(map[string]map[string]interface{})
+		byGroup := make(map[string][]dateRow)
+
+		err := readRecords(file, format, dateFields, opts, ret, func(row int, rec []string, guessHeader bool) error {
+			date, err := NewDateFromCSV(s, rec)
+			if err != nil {
+				if err == ErrNilTarget {
+					Log.Debug("Ignoring row with empty target")
+					ret.addRow(row, csvGroup(rec), rowSkippedEmptyTarget, nil)
+					return nil
+				}
+				if !guessHeader {
+					Log.Error("Failed to parse Date", "row", rec, "error", err)
+					ret.addRow(row, csvGroup(rec), rowError, err)
+					return nil
+				}
+				Log.Debug("Ignoring first row; presuming it is a header")
+				ret.addRow(row, "", rowHeader, nil)
+				return nil // assume first row is header and skip
+			}
+			Log.Debug("Got Date row", "date", date)
+
+			// Confirm group exists
+			g, err := s.Group(date.Group)
+			if err != nil {
+				Log.Error("Failed to load group", "group", date.Group)
+				ret.addRow(row, date.Group, rowError, fmt.Errorf("Failed to load group: %s", err.Error()))
+				return nil
+			}
+
+			if _, ok := before[g.ID]; !ok {
+				before[g.ID] = dateSnapshot(s, g)
+				groups = append(groups, g)
+			}
+			byGroup[g.ID] = append(byGroup[g.ID], dateRow{Row: row, Date: date})
+			ret.addRow(row, g.ID, rowSaved, nil)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return saveDates(s, opts, ret, groups, before, byGroup)
+	})
+	return importResponse(ctx, ret, err)
+}
+
+// saveDates writes the parsed Date rows of each group, according to
+// the write strategy, and completes the import (see saveImport)
+func saveDates(s Store, opts *importOptions, ret *ImportResult, groups []*Group, before map[string]map[string]interface{}, byGroup map[string][]dateRow) error {
+	var rows []dateRow
+	for _, g := range groups {
+		rows = append(rows, byGroup[g.ID]...)
+	}
+	return saveImport(s, opts, ret, groups, before, dateSnapshot, dateOverlaps(rows), func(g *Group) ([]RowIssue, error) {
+		return writeDates(s, g, opts.Write, byGroup[g.ID])
+	})
+}
+
+// saveImport writes the parsed rows of each group with write, which
+// returns the rows which overlap entries of the schedule which are
+// kept, and completes the import (see finishImport) with those and
+// the given overlaps among the rows.  `before` holds the snapshot of
+// each group's entries prior to the import, to be compared with
+// the snapshot after.
+func saveImport(s Store, opts *importOptions, ret *ImportResult, groups []*Group, before map[string]map[string]interface{},
+	snapshot func(s Store, g *Group) map[string]interface{}, overlaps []RowIssue, write func(g *Group) ([]RowIssue, error)) error {
+	if len(ret.Errors) > 0 {
+		return finishImport(s, opts, ret, groups, nil)
+	}
+
+	var conflicts []RowIssue
+	for _, g := range groups {
+		c, err := write(g)
+		if err != nil {
+			return err
+		}
+		conflicts = append(conflicts, c...)
+		ret.Changes = append(ret.Changes, diffEntries(g.ID, before[g.ID], snapshot(s, g)))
+	}
+
+	Log.Debug("Finished import", "groups", len(groups), "rowCount", len(ret.Rows))
+	return finishImport(s, opts, ret, groups, append(overlaps, conflicts...))
+}
+
+// writeDates saves the uploaded rows of a group according to the
+// write strategy, returning the rows which overlap entries of the
+// schedule which are kept
+func writeDates(s Store, g *Group, mode string, rows []dateRow) ([]RowIssue, error) {
+	existing, err := s.Dates(g)
+	if err != nil {
+		return nil, err
+	}
+
+	var kept []Date
+	switch mode {
+	case writeMerge:
+		kept = existing
+	case writeReplaceRange:
+		// Replace every date starting on any calendar day (in the
+		// group's location) spanned by the upload
+		var first, last time.Time
+		for i, r := range rows {
+			if i == 0 || r.Date.Date.Before(first) {
+				first = r.Date.Date
+			}
+			if i == 0 || r.Date.Date.After(last) {
+				last = r.Date.Date
+			}
+		}
+		if loc, err := g.GetLocation(); err == nil {
+			first, last = first.In(loc), last.In(loc)
+		}
+		from := timeOfLastMidnight(first)
+		to := timeOfLastMidnight(last).AddDate(0, 0, 1)
+		Log.Debug("Replacing dates in range", "group", g.ID, "from", from, "to", to)
+		kept = datesOutside(existing, from, to)
+	}
+	conflicts := dateConflicts(rows, kept)
+
+	dates := kept
+	for _, r := range rows {
+		dates = append(dates, *r.Date)
+	}
+	if err = s.ReplaceDates(g, dates); err != nil {
+		Log.Error("Failed to save the dates", "group", g.ID, "error", err)
+		return nil, err
+	}
+	Log.Debug("Saved dates", "group", g.ID, "count", len(rows))
+	return conflicts, nil
+}
+
+// datesOutside returns the Dates which do not start within the
+// given range
+func datesOutside(dates []Date, from, to time.Time) (ret []Date) {
+	for _, d := range dates {
+		if d.Date.Before(from) || !d.Date.Before(to) {
+			ret = append(ret, d)
+		}
+	}
+	return
+}
+
+func importDays(ctx *echo.Context, file io.Reader, format string) error {
+	opts, err := parseImportOptions(ctx)
+	if err != nil {
+		return ctx.String(400, err.Error())
+	}
+
+	ret := newImportResult(opts)
+	err = storeFromContext(ctx).Update(func(s Store) error {
+		Log.Debug("Got a Days upload request", "mode", opts.Write)
+
+		var groups []*Group
+		before := make(map[string]map[string]interface{})
+		byGroup := make(map[string][]dayRow)
+
+		err := readRecords(file, format, dayFields, opts, ret, func(row int, rec []string, guessHeader bool) error {
+			Log.Debug("Got Day row", "day", rec)
+
+			// Convert the row to a Day
+			day, err := NewDayFromCSVRow(rec)
+			if err != nil {
+				if err == ErrNilTarget {
+					Log.Debug("Ignoring row with empty target")
+					ret.addRow(row, csvGroup(rec), rowSkippedEmptyTarget, nil)
+					return nil
+				}
+				if !guessHeader {
+					Log.Error("Failed to parse Day", "row", rec, "error", err)
+					ret.addRow(row, csvGroup(rec), rowError, err)
+					return nil
+				}
+				Log.Debug("Ignoring first row; presuming it is a header")
+				ret.addRow(row, "", rowHeader, nil)
+				return nil // assume first row is header and skip
+			}
+
+			// Confirm group exists
+			g, err := s.Group(day.Group)
+			if err != nil {
+				Log.Error("Failed to load group", "group", day.Group)
+				ret.addRow(row, day.Group, rowError, fmt.Errorf("Failed to load group: %s", err.Error()))
+				return nil
+			}
+
+			if _, ok := before[g.ID]; !ok {
+				before[g.ID] = daySnapshot(s, g)
+				groups = append(groups, g)
+			}
+
+			// Copy over location to day entity
+			Log.Debug("Setting location", "location", g.Location)
+			day.Location = g.Location
+
+			byGroup[g.ID] = append(byGroup[g.ID], dayRow{Row: row, Day: day})
+			ret.addRow(row, g.ID, rowSaved, nil)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return saveDays(s, opts, ret, groups, before, byGroup)
+	})
+	return importResponse(ctx, ret, err)
+}
+
+// saveDays writes the parsed Day rows of each group, according to
+// the write strategy, and completes the import (see saveImport)
+func saveDays(s Store, opts *importOptions, ret *ImportResult, groups []*Group, before map[string]map[string]interface{}, byGroup map[string][]dayRow) error {
+	var rows []dayRow
+	for _, g := range groups {
+		rows = append(rows, byGroup[g.ID]...)
+	}
+	return saveImport(s, opts, ret, groups, before, daySnapshot, dayOverlaps(rows), func(g *Group) ([]RowIssue, error) {
+		return writeDays(s, g, opts.Write, byGroup[g.ID])
+	})
+}
+
+// writeDays saves the uploaded rows of a group according to the
+// write strategy, returning the rows which overlap entries of the
+// schedule which are kept
+func writeDays(s Store, g *Group, mode string, rows []dayRow) ([]RowIssue, error) {
+	existing, err := s.Days(g)
+	if err != nil {
+		return nil, err
+	}
+
+	var kept []Day
+	switch mode {
+	case writeMerge:
+		kept = existing
+	case writeReplaceRange:
+		// Replace every day of the week present in the upload
+		weekdays := make(map[time.Weekday]bool)
+		for _, r := range rows {
+			weekdays[r.Day.Day] = true
+		}
+		Log.Debug("Replacing days of the week", "group", g.ID, "count", len(weekdays))
+		kept = daysNotOn(existing, weekdays)
+	}
+	conflicts := dayConflicts(rows, kept)
+
+	days := kept
+	for _, r := range rows {
+		days = append(days, *r.Day)
+	}
+	if err = s.ReplaceDays(g, days); err != nil {
+		Log.Error("Failed to save the days", "group", g.ID, "error", err)
+		return nil, err
+	}
+	Log.Debug("Saved days", "group", g.ID, "count", len(rows))
+	return conflicts, nil
+}
+
+// daysNotOn returns the Days which do not fall on any of the given
+// days of the week
+func daysNotOn(days []Day, weekdays map[time.Weekday]bool) (ret []Day) {
+	for _, d := range days {
+		if !weekdays[d.Day] {
+			ret = append(ret, d)
+		}
+	}
+	return
+}
+
+// newImportResult returns an empty ImportResult for an import
+// with the given options
+func newImportResult(opts *importOptions) *ImportResult {
+	return &ImportResult{
+		Coverage: []*CoverageReport{},
+		Warnings: []RowIssue{},
+		Errors:   []RowIssue{},
+		DryRun:   opts.DryRun,
+		Changes:  []GroupDiff{},
+		Rows:     []RowResult{},
+		Groups:   make(map[string]*GroupCounts),
+	}
+}
+
+// finishImport completes an import transaction:  it rejects the
+// import if any row failed, applies the overlap policy to the given
+// overlaps, and analyzes the coverage, over the coming week, of each
+// imported group.  The returned error determines whether the
+// transaction is committed.
+func finishImport(s Store, opts *importOptions, ret *ImportResult, groups []*Group, overlaps []RowIssue) error {
+	if len(ret.Errors) > 0 {
+		ret.Warnings = append(ret.Warnings, overlaps...)
+		ret.reject()
+		return errImportRejected
+	}
+	if opts.Overlap == overlapStrict && len(overlaps) > 0 {
+		ret.Errors = append(ret.Errors, overlaps...)
+		ret.reject()
+		return errImportRejected
+	}
+	ret.Warnings = append(ret.Warnings, overlaps...)
+
+	now := time.Now()
+	for _, g := range groups {
+		r, err := coverageOf(s, g, now, now.Add(defaultCoverageSpan))
+		if err != nil {
+			return err
+		}
+		if !r.Covered || len(r.Overlaps) > 0 {
+			Log.Warn("Imported schedule is incomplete", "group", g.ID, "gaps", len(r.Gaps), "overlaps", len(r.Overlaps))
+		}
+		ret.Coverage = append(ret.Coverage, r)
+	}
+
+	if opts.DryRun {
+		return errDryRun
+	}
+	return nil
+}
+
+// importResponse responds with the ImportResult of an import,
+// given the error which ended its transaction
+func importResponse(ctx *echo.Context, ret *ImportResult, err error) error {
+	switch err {
+	case nil, errDryRun:
+		return ctx.JSON(200, ret)
+	case errImportRejected:
+		Log.Info("Rejected import", "errors", len(ret.Errors))
+		return ctx.JSON(400, ret)
+	default:
+		return err
+	}
+}
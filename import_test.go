@@ -0,0 +1,485 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDayOverlaps(t *testing.T) {
+	Convey("Given Monday 02:00-06:00 and Monday 05:00-07:00 rows for one group", t, func() {
+		rows := []dayRow{
+			dayRow{Row: 1, Day: &Day{Group: "a", Target: "1", Day: time.Monday, Start: 2 * time.Hour, Duration: 4 * time.Hour}},
+			dayRow{Row: 2, Day: &Day{Group: "a", Target: "2", Day: time.Monday, Start: 5 * time.Hour, Duration: 2 * time.Hour}},
+		}
+
+		Convey("The second row should be reported as overlapping the first", func() {
+			issues := dayOverlaps(rows)
+			So(issues, ShouldHaveLength, 1)
+			So(issues[0].Row, ShouldEqual, 2)
+			So(issues[0].Group, ShouldEqual, "a")
+		})
+
+		Convey("Rows of different groups should not be reported", func() {
+			rows[1].Day.Group = "b"
+			So(dayOverlaps(rows), ShouldBeEmpty)
+		})
+	})
+
+	Convey("Given two rows with the same day and start time", t, func() {
+		rows := []dayRow{
+			dayRow{Row: 1, Day: &Day{Group: "a", Target: "1", Day: time.Monday, Start: 2 * time.Hour, Duration: time.Hour}},
+			dayRow{Row: 3, Day: &Day{Group: "a", Target: "2", Day: time.Monday, Start: 2 * time.Hour, Duration: time.Hour}},
+		}
+
+		Convey("The second row should be reported as replacing the first", func() {
+			issues := dayOverlaps(rows)
+			So(issues, ShouldHaveLength, 1)
+			So(issues[0].Row, ShouldEqual, 3)
+			So(issues[0].Message, ShouldContainSubstring, "replaces")
+		})
+	})
+}
+
+func TestDateOverlaps(t *testing.T) {
+	Convey("Given back-to-back Date rows and a third overlapping row", t, func() {
+		start := time.Date(2016, 02, 13, 2, 0, 0, 0, loc)
+		rows := []dateRow{
+			dateRow{Row: 1, Date: &Date{Group: "a", Target: "1", Date: start, Time: 4 * time.Hour}},
+			dateRow{Row: 2, Date: &Date{Group: "a", Target: "2", Date: start.Add(4 * time.Hour), Time: 4 * time.Hour}},
+			dateRow{Row: 3, Date: &Date{Group: "a", Target: "3", Date: start.Add(7 * time.Hour), Time: 4 * time.Hour}},
+		}
+
+		Convey("Only the third row should be reported", func() {
+			issues := dateOverlaps(rows)
+			So(issues, ShouldHaveLength, 1)
+			So(issues[0].Row, ShouldEqual, 3)
+			So(issues[0].Message, ShouldContainSubstring, "row 2")
+		})
+	})
+}
+
+func TestDiffEntries(t *testing.T) {
+	Convey("Given two snapshots of a group's days", t, func() {
+		before := map[string]interface{}{
+			"1:120": DayExternal{Group: "a", Target: "1", Day: "Monday", Start: "02:00", Stop: "06:00"},
+			"2:120": DayExternal{Group: "a", Target: "2", Day: "Tuesday", Start: "02:00", Stop: "06:00"},
+		}
+		after := map[string]interface{}{
+			"1:120": DayExternal{Group: "a", Target: "9", Day: "Monday", Start: "02:00", Stop: "06:00"},
+			"3:120": DayExternal{Group: "a", Target: "3", Day: "Wednesday", Start: "02:00", Stop: "06:00"},
+		}
+
+		Convey("The diff should list one added, one removed, and one changed entry", func() {
+			diff := diffEntries("a", before, after)
+			So(diff.Group, ShouldEqual, "a")
+			So(diff.Added, ShouldHaveLength, 1)
+			So(diff.Added[0].Key, ShouldEqual, "3:120")
+			So(diff.Removed, ShouldHaveLength, 1)
+			So(diff.Removed[0].Key, ShouldEqual, "2:120")
+			So(diff.Changed, ShouldHaveLength, 1)
+			So(diff.Changed[0].Key, ShouldEqual, "1:120")
+			So(diff.Changed[0].Before, ShouldResemble, before["1:120"])
+			So(diff.Changed[0].After, ShouldResemble, after["1:120"])
+		})
+
+		Convey("Identical snapshots should have an empty diff", func() {
+			diff := diffEntries("a", before, before)
+			So(diff.Added, ShouldBeEmpty)
+			So(diff.Removed, ShouldBeEmpty)
+			So(diff.Changed, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestImportResultAddRow(t *testing.T) {
+	Convey("Given an empty ImportResult", t, func() {
+		ret := newImportResult(&importOptions{Overlap: overlapWarn})
+
+		Convey("Adding a header, two saved rows, a skipped row, and an error row", func() {
+			ret.addRow(1, "", rowHeader, nil)
+			ret.addRow(2, "a", rowSaved, nil)
+			ret.addRow(3, "a", rowSaved, nil)
+			ret.addRow(4, "a", rowSkippedEmptyTarget, nil)
+			ret.addRow(5, "b", rowError, fmt.Errorf("Failed to parse day of the week"))
+
+			Convey("Every row should be reported", func() {
+				So(ret.Rows, ShouldHaveLength, 5)
+				So(ret.Rows[0].Status, ShouldEqual, rowHeader)
+				So(ret.Rows[4].Error, ShouldEqual, "Failed to parse day of the week")
+			})
+
+			Convey("The rows should be counted per group", func() {
+				So(ret.Groups, ShouldHaveLength, 2)
+				So(*ret.Groups["a"], ShouldResemble, GroupCounts{Saved: 2, Skipped: 1})
+				So(*ret.Groups["b"], ShouldResemble, GroupCounts{Errors: 1})
+			})
+
+			Convey("The error row should be listed as an error", func() {
+				So(ret.Errors, ShouldHaveLength, 1)
+				So(ret.Errors[0].Row, ShouldEqual, 5)
+				So(ret.Errors[0].Group, ShouldEqual, "b")
+			})
+		})
+	})
+}
+
+func TestImportConflicts(t *testing.T) {
+	Convey("Given an existing Monday 02:00-06:00 Day", t, func() {
+		existing := []Day{
+			Day{Group: "a", Target: "1", Day: time.Monday, Start: 2 * time.Hour, Duration: 4 * time.Hour},
+		}
+
+		Convey("An uploaded row overlapping it should be reported", func() {
+			rows := []dayRow{
+				dayRow{Row: 1, Day: &Day{Group: "a", Target: "2", Day: time.Monday, Start: 5 * time.Hour, Duration: 2 * time.Hour}},
+			}
+			issues := dayConflicts(rows, existing)
+			So(issues, ShouldHaveLength, 1)
+			So(issues[0].Message, ShouldContainSubstring, "existing")
+		})
+
+		Convey("An uploaded row replacing it by key should not be reported", func() {
+			rows := []dayRow{
+				dayRow{Row: 1, Day: &Day{Group: "a", Target: "2", Day: time.Monday, Start: 2 * time.Hour, Duration: 2 * time.Hour}},
+			}
+			So(dayConflicts(rows, existing), ShouldBeEmpty)
+		})
+	})
+
+	Convey("Given an existing Date", t, func() {
+		start := time.Date(2016, 02, 13, 2, 0, 0, 0, loc)
+		existing := []Date{
+			Date{Group: "a", Target: "1", Date: start, Time: 4 * time.Hour},
+		}
+
+		Convey("Only the uploaded row overlapping it should be reported", func() {
+			rows := []dateRow{
+				dateRow{Row: 1, Date: &Date{Group: "a", Target: "2", Date: start.Add(3 * time.Hour), Time: 4 * time.Hour}},
+				dateRow{Row: 2, Date: &Date{Group: "a", Target: "3", Date: start.Add(4 * time.Hour), Time: 4 * time.Hour}},
+			}
+			issues := dateConflicts(rows, existing)
+			So(issues, ShouldHaveLength, 1)
+			So(issues[0].Row, ShouldEqual, 1)
+		})
+	})
+}
+
+func TestReplaceRange(t *testing.T) {
+	Convey("Given Monday and Tuesday Days", t, func() {
+		var days []Day
+		for _, wd := range []time.Weekday{time.Monday, time.Tuesday} {
+			days = append(days, Day{Group: "a", Target: "1", Day: wd, Start: time.Hour, Duration: time.Hour})
+		}
+
+		Convey("Replacing the Mondays should keep only the Tuesday", func() {
+			kept := daysNotOn(days, map[time.Weekday]bool{time.Monday: true})
+			So(kept, ShouldHaveLength, 1)
+			So(kept[0].Day, ShouldEqual, time.Tuesday)
+		})
+	})
+
+	Convey("Given Dates on three consecutive days", t, func() {
+		start := time.Date(2016, 02, 13, 9, 0, 0, 0, loc)
+		var dates []Date
+		for i := 0; i < 3; i++ {
+			dates = append(dates, Date{Group: "a", Target: "1", Date: start.AddDate(0, 0, i), Time: time.Hour})
+		}
+
+		Convey("Replacing the middle day should keep the first and last", func() {
+			from := time.Date(2016, 02, 14, 0, 0, 0, 0, loc)
+			kept := datesOutside(dates, from, from.AddDate(0, 0, 1))
+			So(kept, ShouldHaveLength, 2)
+			for _, d := range kept {
+				So(d.Date.Day(), ShouldNotEqual, 14)
+			}
+		})
+	})
+}
+
+func TestImportRejected(t *testing.T) {
+	Convey("Given an import of two valid rows and an error row", t, func() {
+		s := newMemoryStore()
+		g := &Group{ID: "a", Name: "a", Location: locString, DefaultTarget: "100"}
+		So(s.SaveGroup(g), ShouldBeNil)
+
+		ret := newImportResult(&importOptions{Overlap: overlapWarn})
+		ret.addRow(1, "a", rowSaved, nil)
+		ret.addRow(2, "a", rowSaved, nil)
+		ret.addRow(3, "a", rowError, fmt.Errorf("Failed to parse day of the week"))
+
+		Convey("Finishing it should reject it", func() {
+			So(finishImport(s, &importOptions{Overlap: overlapWarn}, ret, []*Group{g}, nil), ShouldEqual, errImportRejected)
+
+			Convey("The valid rows should be reported as rejected, not saved", func() {
+				So(ret.Rows[0].Status, ShouldEqual, rowRejected)
+				So(ret.Rows[1].Status, ShouldEqual, rowRejected)
+				So(ret.Rows[2].Status, ShouldEqual, rowError)
+				So(*ret.Groups["a"], ShouldResemble, GroupCounts{Rejected: 2, Errors: 1})
+			})
+		})
+	})
+
+	Convey("Given an import of two overlapping rows", t, func() {
+		s := newMemoryStore()
+		g := &Group{ID: "a", Name: "a", Location: locString, DefaultTarget: "100"}
+		So(s.SaveGroup(g), ShouldBeNil)
+
+		ret := newImportResult(&importOptions{Overlap: overlapStrict})
+		ret.addRow(1, "a", rowSaved, nil)
+		ret.addRow(2, "a", rowSaved, nil)
+		overlaps := []RowIssue{RowIssue{Row: 2, Group: "a", Message: "Overlaps row 1"}}
+
+		Convey("Finishing it under the strict policy should reject it", func() {
+			So(finishImport(s, &importOptions{Overlap: overlapStrict}, ret, []*Group{g}, overlaps), ShouldEqual, errImportRejected)
+			So(ret.Errors, ShouldResemble, overlaps)
+			So(ret.Rows[0].Status, ShouldEqual, rowRejected)
+			So(ret.Rows[1].Status, ShouldEqual, rowRejected)
+			So(*ret.Groups["a"], ShouldResemble, GroupCounts{Rejected: 2})
+		})
+
+		Convey("Finishing it under the warn policy should keep the rows saved", func() {
+			So(finishImport(s, &importOptions{Overlap: overlapWarn}, ret, []*Group{g}, overlaps), ShouldBeNil)
+			So(ret.Rows[0].Status, ShouldEqual, rowSaved)
+			So(*ret.Groups["a"], ShouldResemble, GroupCounts{Saved: 2})
+		})
+	})
+}
+
+// importDaysInto imports the given Days into the Store, within a
+// transaction, as an upload of them would
+func importDaysInto(s Store, opts *importOptions, days []Day) (*ImportResult, error) {
+	ret := newImportResult(opts)
+	err := s.Update(func(s Store) error {
+		var groups []*Group
+		before := make(map[string]map[string]interface{})
+		byGroup := make(map[string][]dayRow)
+		for i := range days {
+			g, err := s.Group(days[i].Group)
+			if err != nil {
+				return err
+			}
+			if _, ok := before[g.ID]; !ok {
+				before[g.ID] = daySnapshot(s, g)
+				groups = append(groups, g)
+			}
+			byGroup[g.ID] = append(byGroup[g.ID], dayRow{Row: i + 1, Day: &days[i]})
+			ret.addRow(i+1, g.ID, rowSaved, nil)
+		}
+		return saveDays(s, opts, ret, groups, before, byGroup)
+	})
+	return ret, err
+}
+
+// importDatesInto imports the given Dates into the Store, within a
+// transaction, as an upload of them would
+func importDatesInto(s Store, opts *importOptions, dates []Date) (*ImportResult, error) {
+	ret := newImportResult(opts)
+	err := s.Update(func(s Store) error {
+		var groups []*Group
+		before := make(map[string]map[string]interface{})
+		byGroup := make(map[string][]dateRow)
+		for i := range dates {
+			g, err := s.Group(dates[i].Group)
+			if err != nil {
+				return err
+			}
+			if _, ok := before[g.ID]; !ok {
+				before[g.ID] = dateSnapshot(s, g)
+				groups = append(groups, g)
+			}
+			byGroup[g.ID] = append(byGroup[g.ID], dateRow{Row: i + 1, Date: &dates[i]})
+			ret.addRow(i+1, g.ID, rowSaved, nil)
+		}
+		return saveDates(s, opts, ret, groups, before, byGroup)
+	})
+	return ret, err
+}
+
+// testImportStore checks that an import which is not to be saved
+// leaves the Store unchanged
+func testImportStore(t *testing.T, name string, s Store) {
+	g := &Group{ID: "testImportGroup", Name: "testImportGroup", Location: locString, DefaultTarget: "100"}
+	monday := Day{Group: g.ID, Target: "200", Day: time.Monday, Start: 9 * time.Hour, Duration: 8 * time.Hour, Location: locString}
+	date := Date{Group: g.ID, Target: "300", Date: time.Date(2016, 01, 25, 12, 0, 0, 0, loc), Time: time.Hour}
+
+	Convey("Given a "+name+" with a Monday Day and a Date", t, func() {
+		So(s.SaveGroup(g), ShouldBeNil)
+		So(s.ReplaceDays(g, []Day{monday}), ShouldBeNil)
+		So(s.ReplaceDates(g, []Date{date}), ShouldBeNil)
+
+		Convey("A dry run should report the changes, but not save them", func() {
+			changed := monday
+			changed.Target = "201"
+			tuesday := Day{Group: g.ID, Target: "202", Day: time.Tuesday, Start: 9 * time.Hour, Duration: time.Hour, Location: locString}
+
+			ret, err := importDaysInto(s, &importOptions{Write: writeMerge, Overlap: overlapWarn, DryRun: true}, []Day{changed, tuesday})
+			So(err, ShouldEqual, errDryRun)
+			So(ret.Errors, ShouldBeEmpty)
+			So(ret.Changes, ShouldHaveLength, 1)
+			So(ret.Changes[0].Added, ShouldHaveLength, 1)
+			So(ret.Changes[0].Added[0].Key, ShouldEqual, string(tuesday.Key()))
+			So(ret.Changes[0].Removed, ShouldBeEmpty)
+			So(ret.Changes[0].Changed, ShouldHaveLength, 1)
+			So(ret.Changes[0].Changed[0].Key, ShouldEqual, string(monday.Key()))
+			So(ret.Changes[0].Changed[0].Before.(DayExternal).Target, ShouldEqual, "200")
+			So(ret.Changes[0].Changed[0].After.(DayExternal).Target, ShouldEqual, "201")
+
+			days, err := s.Days(g)
+			So(err, ShouldBeNil)
+			So(days, ShouldHaveLength, 1)
+			So(days[0].Target, ShouldEqual, "200")
+		})
+
+		Convey("A Day overlapping a kept Day should be rejected under the strict policy", func() {
+			noon := Day{Group: g.ID, Target: "203", Day: time.Monday, Start: 12 * time.Hour, Duration: time.Hour, Location: locString}
+
+			ret, err := importDaysInto(s, &importOptions{Write: writeMerge, Overlap: overlapStrict}, []Day{noon})
+			So(err, ShouldEqual, errImportRejected)
+			So(ret.Errors, ShouldHaveLength, 1)
+			So(ret.Changes, ShouldHaveLength, 1)
+			So(ret.Changes[0].Added, ShouldHaveLength, 1)
+			So(ret.Changes[0].Added[0].Key, ShouldEqual, string(noon.Key()))
+
+			days, err := s.Days(g)
+			So(err, ShouldBeNil)
+			So(days, ShouldHaveLength, 1)
+			So(days[0].Start, ShouldEqual, monday.Start)
+			So(days[0].Target, ShouldEqual, "200")
+		})
+
+		Convey("A Date overlapping a kept Date should be rejected under the strict policy", func() {
+			later := Date{Group: g.ID, Target: "301", Date: date.Date.Add(30 * time.Minute), Time: time.Hour}
+
+			ret, err := importDatesInto(s, &importOptions{Write: writeMerge, Overlap: overlapStrict}, []Date{later})
+			So(err, ShouldEqual, errImportRejected)
+			So(ret.Errors, ShouldHaveLength, 1)
+			So(ret.Changes, ShouldHaveLength, 1)
+			So(ret.Changes[0].Added, ShouldHaveLength, 1)
+			So(ret.Changes[0].Added[0].Key, ShouldEqual, string(later.Key()))
+
+			dates, err := s.Dates(g)
+			So(err, ShouldBeNil)
+			So(dates, ShouldHaveLength, 1)
+			So(dates[0].Date.Equal(date.Date), ShouldBeTrue)
+			So(dates[0].Target, ShouldEqual, "300")
+		})
+	})
+}
+
+// scheduleTargets returns the target of each Day and Date of the
+// group, as held by the given Store, by key
+func scheduleTargets(s Store, g *Group) (days, dates map[string]string) {
+	days = make(map[string]string)
+	dates = make(map[string]string)
+	dl, _ := s.Days(g)
+	for _, d := range dl {
+		days[string(d.Key())] = d.Target
+	}
+	tl, _ := s.Dates(g)
+	for _, d := range tl {
+		dates[string(d.Key())] = d.Target
+	}
+	return
+}
+
+// testImportWrite checks that each write strategy leaves the right
+// entries in the Store
+func testImportWrite(t *testing.T, name string, s Store) {
+	g := &Group{ID: "testWriteGroup", Name: "testWriteGroup", Location: locString, DefaultTarget: "100"}
+	day := func(target string, wd time.Weekday, start, duration time.Duration) Day {
+		return Day{Group: g.ID, Target: target, Day: wd, Start: start, Duration: duration, Location: locString}
+	}
+	date := func(target string, day, hour int) Date {
+		return Date{Group: g.ID, Target: target, Date: time.Date(2016, 01, day, hour, 0, 0, 0, loc), Time: time.Hour}
+	}
+
+	mondayMorning := day("200", time.Monday, 9*time.Hour, 3*time.Hour)
+	mondayAfternoon := day("201", time.Monday, 13*time.Hour, 4*time.Hour)
+	tuesday := day("210", time.Tuesday, 9*time.Hour, 8*time.Hour)
+	first := date("300", 25, 9)
+	second := date("301", 25, 15)
+	third := date("302", 27, 9)
+
+	Convey("Given a "+name+" with Days on Monday and Tuesday, and Dates on the 25th and 27th", t, func() {
+		So(s.SaveGroup(g), ShouldBeNil)
+		So(s.ReplaceDays(g, []Day{mondayMorning, mondayAfternoon, tuesday}), ShouldBeNil)
+		So(s.ReplaceDates(g, []Date{first, second, third}), ShouldBeNil)
+
+		days := []Day{day("202", time.Monday, 9*time.Hour, 3*time.Hour), day("220", time.Wednesday, 9*time.Hour, time.Hour)}
+		dates := []Date{date("303", 25, 9), date("304", 26, 12)}
+		write := func(mode string) {
+			_, err := importDaysInto(s, &importOptions{Write: mode, Overlap: overlapWarn}, days)
+			So(err, ShouldBeNil)
+			_, err = importDatesInto(s, &importOptions{Write: mode, Overlap: overlapWarn}, dates)
+			So(err, ShouldBeNil)
+		}
+
+		Convey("Replacing should keep only the uploaded entries", func() {
+			write(writeReplace)
+			gotDays, gotDates := scheduleTargets(s, g)
+			So(gotDays, ShouldResemble, map[string]string{
+				string(mondayMorning.Key()): "202",
+				string(days[1].Key()):       "220",
+			})
+			So(gotDates, ShouldResemble, map[string]string{
+				string(first.Key()):    "303",
+				string(dates[1].Key()): "304",
+			})
+		})
+
+		Convey("Merging should keep every existing entry, replacing those with the same key", func() {
+			write(writeMerge)
+			gotDays, gotDates := scheduleTargets(s, g)
+			So(gotDays, ShouldResemble, map[string]string{
+				string(mondayMorning.Key()):   "202",
+				string(mondayAfternoon.Key()): "201",
+				string(tuesday.Key()):         "210",
+				string(days[1].Key()):         "220",
+			})
+			So(gotDates, ShouldResemble, map[string]string{
+				string(first.Key()):    "303",
+				string(second.Key()):   "301",
+				string(dates[1].Key()): "304",
+				string(third.Key()):    "302",
+			})
+		})
+
+		Convey("Replacing the range should keep only the entries outside of the upload's days", func() {
+			write(writeReplaceRange)
+			gotDays, gotDates := scheduleTargets(s, g)
+			So(gotDays, ShouldResemble, map[string]string{
+				string(mondayMorning.Key()): "202",
+				string(tuesday.Key()):       "210",
+				string(days[1].Key()):       "220",
+			})
+			So(gotDates, ShouldResemble, map[string]string{
+				string(first.Key()):    "303",
+				string(dates[1].Key()): "304",
+				string(third.Key()):    "302",
+			})
+		})
+	})
+}
+
+func TestImportMemoryStore(t *testing.T) {
+	testImportStore(t, "memory store", newMemoryStore())
+	testImportWrite(t, "memory store", newMemoryStore())
+}
+
+func TestImportBoltStore(t *testing.T) {
+	db, err := dbOpen("./importTest.db")
+	if err != nil {
+		panic("Failed to open test database")
+	}
+	defer func() {
+		db.Close()
+		os.Remove("./importTest.db")
+	}()
+
+	testImportStore(t, "Bolt store", newBoltStore(db))
+	testImportWrite(t, "Bolt store", newBoltStore(db))
+}
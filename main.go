@@ -3,9 +3,8 @@ package main
 //go:generate esc -o static.go -prefix public -ignore \.map$ public
 
 import (
-	"encoding/csv"
+	"encoding/json"
 	"flag"
-	"io"
 	"net/http"
 	"os"
 	"os/signal"
@@ -24,18 +23,29 @@ import (
 var dbFile = "/var/db/ringfree/ipc.db"
 var db *bolt.DB
 
+// storeType is the kind of database at dbFile: bolt or sqlite
+var storeType = "bolt"
+
 // addr is the listen address
 var addr string
 
 // agiaddr is the listen address for the FastAGI service
 var agiaddr string
 
+// agiTrace enables logging of the target resolution
+// trace for each FastAGI request
+var agiTrace bool
+
+// migrateOnly migrates the database to the latest schema
+// version and exits, without starting the service
+var migrateOnly bool
+
 // debug enables debug mode, which uses local files
 // instead of bundled ones
 var debug bool
 
 // Log is the top-level logger
-var Log log15.Logger
+var Log = log15.New()
 
 // ErrNilTarget indicates that the row/day/date has no target
 // specified.
@@ -45,6 +55,11 @@ func init() {
 	flag.StringVar(&addr, "addr", ":9000", "Address binding")
 	flag.StringVar(&agiaddr, "agiaddr", ":9001", "Address binding for FastAGI service")
 	flag.BoolVar(&debug, "debug", false, "Enable debug mode, which uses separate files for web development")
+	flag.BoolVar(&agiTrace, "agitrace", false, "Log the target resolution trace of each FastAGI request at debug level")
+	flag.StringVar(&dbFile, "db", dbFile, "Path to the schedule database")
+	flag.StringVar(&storeType, "store", storeType, "Kind of schedule database: bolt or sqlite")
+	flag.BoolVar(&migrateOnly, "migrate-only", false, "Migrate the schedule database to the latest schema version and exit")
+	flag.Usage = usage
 }
 
 func main() {
@@ -53,13 +68,39 @@ func main() {
 	// Create a logger
 	Log = log15.New()
 
+	// Run a command instead of the service, if one is given
+	if flag.NArg() > 0 {
+		Log.SetHandler(log15.StreamHandler(os.Stderr, log15.LogfmtFormat()))
+		os.Exit(runCommand(flag.Args()))
+	}
+
 	// Open the database
-	db, err := dbOpen(dbFile)
+	var store Store
+	var version int // schema version of the database, once opened
+	var err error
+	switch storeType {
+	case "bolt":
+		if db, err = dbOpen(dbFile); err == nil {
+			store = newBoltStore(db)
+		}
+		version = latestSchemaVersion()
+	case "sqlite":
+		store, err = sqlOpen(dbFile)
+		version = sqlSchemaVersion
+	default:
+		err = errors.Errorf("unknown store %s", storeType)
+	}
 	if err != nil {
 		Log.Crit("Failed to open schedule database", "error", err)
 		return
 	}
-	defer db.Close()
+	defer store.Close()
+	store = newScheduleCache(store)
+
+	if migrateOnly {
+		Log.Info("Database is up to date", "store", storeType, "version", version)
+		return
+	}
 
 	// Create Echo web server
 	e := echo.New()
@@ -69,6 +110,7 @@ func main() {
 	e.Use(middleware.Recover())
 	e.Use(func(ctx *echo.Context) error {
 		ctx.Set("db", db)
+		ctx.Set("store", store)
 		return nil
 	})
 
@@ -98,19 +140,46 @@ func main() {
 	// Data endpoints
 
 	e.Get("/target/:id", getTargetHandler)
+	e.Get("/target/:id/explain", explainTargetHandler)
 	e.Get("/groups", getGroups)
 	e.Post("/group", postGroup)
 	e.Get("/group/:id", getGroupHandler)
 	e.Delete("/group/:id", deleteGroupHandler)
-	//e.Put("/group/:id", editGroup)
+	e.Get("/group/:id/days", getDaysHandler)
+	e.Post("/group/:id/days", postDayHandler)
+	e.Get("/group/:id/days/:key", getDayHandler)
+	e.Put("/group/:id/days/:key", putDayHandler)
+	e.Delete("/group/:id/days/:key", deleteDayHandler)
+	e.Get("/group/:id/dates", getDatesHandler)
+	e.Post("/group/:id/dates", postDateHandler)
+	e.Get("/group/:id/dates/:key", getDateHandler)
+	e.Put("/group/:id/dates/:key", putDateHandler)
+	e.Delete("/group/:id/dates/:key", deleteDateHandler)
+	e.Put("/group/:id", editGroup)
 
 	// Import endpoints
 
 	e.Post("/sched/import/days", fileHandler(importDays))
 	e.Post("/sched/import/dates", fileHandler(importDates))
+	e.Post("/sched/import/ical", fileHandler(importICal))
 
 	// Export endpoints
+	e.Get("/sched/export/days.csv", exportDaysCSV)
+	e.Get("/sched/export/dates.csv", exportDatesCSV)
 	e.Get("/sched/export/:id", getScheduleHandler)
+	e.Get("/sched/export/:id/days.csv", exportDaysCSV)
+	e.Get("/sched/export/:id/dates.csv", exportDatesCSV)
+	e.Get("/sched/timeline/:id", getTimelineHandler)
+	e.Get("/sched/coverage/:id", getCoverageHandler)
+	e.Get("/sched/ical/:id", getICalHandler)
+
+	// Admin endpoints
+	if db != nil {
+		e.Get("/admin/backup", backupHandler)
+	}
+	e.Post("/admin/restore", restoreHandler)
+	e.Get("/admin/dump", dumpHandler)
+	e.Post("/admin/purge-orphans", purgeOrphansHandler)
 
 	// Listen to OS kill signals
 	go func() {
@@ -122,159 +191,13 @@ func main() {
 	}()
 
 	// Start FastAGI service
-	go fastAGI(db)
+	go fastAGI(store)
 
 	// Listen for connections
 	Log.Info("Listening", "address", addr)
 	e.Run(addr)
 }
 
-func fileHandler(fn func(ctx *echo.Context, r io.Reader) error) func(ctx *echo.Context) error {
-	return func(ctx *echo.Context) error {
-		// Parse the attached file
-		req := ctx.Request()
-
-		var input io.Reader
-
-		if h, ok := req.Header["Content-Type"]; ok {
-			if h[0] == "text/csv" {
-				i := req.Body
-				defer i.Close()
-
-				input = i
-			} else {
-				i, _, err := req.FormFile("file")
-				if err != nil {
-					return err
-				}
-				defer i.Close()
-
-				input = i
-			}
-		}
-
-		return fn(ctx, input)
-	}
-}
-
-func importDates(ctx *echo.Context, file io.Reader) error {
-	return dbFromContext(ctx).Update(func(tx *bolt.Tx) error {
-		Log.Debug("Got a Dates upload request")
-
-		r := csv.NewReader(file)
-
-		seenGroups := make(map[string]bool)
-
-		var rowCount int
-		var validCount int
-		for rec, err := r.Read(); err == nil; rec, err = r.Read() {
-			rowCount++
-			date, err := NewDateFromCSV(dbFromContext(ctx), rec)
-			if err != nil {
-				if err == ErrNilTarget {
-					Log.Debug("Ignoring row with empty target")
-					continue
-				}
-				if rowCount > 1 {
-					Log.Error("Failed to parse Date", "row", rec, "error", err)
-					return err
-				}
-				Log.Debug("Ignoring first row; presuming it is a header")
-				continue // assume first row is header and skip
-			}
-			Log.Debug("Got Date row", "date", date)
-
-			// Confirm group exists
-			g, err := getGroupWithTx(tx, date.Group)
-			if err != nil {
-				Log.Error("Failed to load group", "group", date.Group)
-				return err
-			}
-
-			// if we haven't seen the group this upload, then clear the dates schedule
-			// of this group
-			if _, ok := seenGroups[g.ID]; !ok {
-				g.ClearDates(tx)
-				seenGroups[g.ID] = true // mark group as seen
-			}
-
-			if err := date.Save(tx); err != nil {
-				Log.Error("Failed to save the date", "date", date)
-				return err
-			}
-			Log.Debug("Saved date", "date", date)
-			validCount++
-		}
-
-		Log.Debug("Finished Dates import", "validCount", validCount, "rowCount", rowCount)
-		return nil
-	})
-}
-
-func importDays(ctx *echo.Context, file io.Reader) error {
-	return dbFromContext(ctx).Update(func(tx *bolt.Tx) error {
-		r := csv.NewReader(file)
-
-		seenGroups := make(map[string]bool)
-
-		var rowCount int
-		var validCount int
-		for rec, err := r.Read(); err == nil; rec, err = r.Read() {
-			Log.Debug("Got Day row", "day", rec)
-			rowCount++
-
-			// Convert the row to a Day
-			day, err := NewDayFromCSVRow(rec)
-			if err != nil {
-				if err == ErrNilTarget {
-					Log.Debug("Ignoring row with empty target")
-					continue
-				}
-				if rowCount > 1 {
-					Log.Error("Failed to parse Day", "row", rec, "error", err)
-					return err
-				}
-				Log.Debug("Ignoring first row; presuming it is a header")
-				continue // assume first row is header and skip
-			}
-
-			// Ignore the row if target is ""
-			if day.Target == "" {
-				continue
-			}
-
-			// Confirm group exists
-			g, err := getGroupWithTx(tx, day.Group)
-			if err != nil {
-				Log.Error("Failed to load group", "group", day.Group)
-				return err
-			}
-
-			// if we haven't seen the group this upload, then clear the days
-			if _, ok := seenGroups[g.ID]; !ok {
-				g.ClearDays(tx)
-				seenGroups[g.ID] = true // mark group as seen
-			}
-
-			// Copy over location to day entity
-			Log.Debug("Setting location", "location", g.Location)
-			day.Location = g.Location
-
-			// Save the Day
-			if err := day.Save(tx); err != nil {
-				Log.Error("Failed to save the day", "day", day)
-				return err
-			}
-
-			validCount++
-			Log.Debug("Saved day", "day", day)
-		}
-
-		Log.Debug("Finished Days import", "validCount", validCount, "rowCount", rowCount)
-		return nil
-	})
-}
-
 // ScheduleDump is a dump of the database of schedules for a group
 type ScheduleDump struct {
 	// Group is the group for which the schedule is presented
@@ -288,29 +211,28 @@ type ScheduleDump struct {
 }
 
 func getScheduleHandler(ctx *echo.Context) error {
-	db := dbFromContext(ctx)
-	s, err := getSchedule(db, ctx.Param("id"))
+	s, err := getSchedule(storeFromContext(ctx), ctx.Param("id"))
 	if err != nil {
 		return ctx.String(500, err.Error())
 	}
 	return ctx.JSON(200, s)
 }
 
-func getSchedule(db *bolt.DB, groupID string) (*ScheduleDump, error) {
+func getSchedule(s Store, groupID string) (*ScheduleDump, error) {
 	// Load the group
-	g, err := getGroup(db, groupID)
+	g, err := s.Group(groupID)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to load group")
 	}
 
 	// Load the Date schedule
-	dates, err := DatesForGroup(db, g)
+	dates, err := s.Dates(g)
 	if err != nil {
 		Log.Error("failed to load dates", "error", err)
 	}
 
 	// Load the Date schedule
-	days, err := DaysForGroup(db, g)
+	days, err := s.Days(g)
 	if err != nil {
 		Log.Error("failed to load days", "error", err)
 	}
@@ -322,10 +244,15 @@ func getSchedule(db *bolt.DB, groupID string) (*ScheduleDump, error) {
 	}, nil
 }
 
-// getTarget returns the target for the present time
+// getTargetHandler returns the target for the present time,
+// or for the time given by the optional RFC3339 `at` parameter
 func getTargetHandler(ctx *echo.Context) error {
-	db := dbFromContext(ctx)
-	t := getTarget(db, ctx.Param("id"))
+	at, err := parseAt(ctx.Query("at"))
+	if err != nil {
+		return ctx.String(400, err.Error())
+	}
+
+	t := getTarget(storeFromContext(ctx), ctx.Param("id"), at)
 	if t == "" {
 		return ctx.String(404, "Not found")
 	}
@@ -333,24 +260,37 @@ func getTargetHandler(ctx *echo.Context) error {
 	return ctx.String(200, t)
 }
 
-// getTarget returns the target for the present time
-func getTarget(db *bolt.DB, groupID string) string {
+// getTarget returns the target for the given time
+func getTarget(s Store, groupID string, at time.Time) string {
+	// Use the compiled schedule, if the store keeps one
+	if c, ok := s.(targetCache); ok {
+		if t, ok := c.cachedTarget(groupID, at); ok {
+			return t
+		}
+	}
+
 	// Load the group
-	g, err := getGroup(db, groupID)
+	g, err := s.Group(groupID)
 	if err != nil {
 		log.Error("Failed to load group", "error", err)
 		return ""
 	}
 
 	// See if we have an explicit date entry
-	d := ActiveDate(db, g, time.Now())
+	d, err := s.ActiveDate(g, at)
+	if err != nil {
+		Log.Error("Failed to load dates", "group", g.ID, "error", err)
+	}
 	if d != nil {
 		Log.Debug("Found matching Date", "day", d)
 		return d.Target
 	}
 
 	// Otherwise, use the day schedule
-	d2 := ActiveDay(db, g, time.Now())
+	d2, err := s.ActiveDay(g, at)
+	if err != nil {
+		Log.Error("Failed to load days", "group", g.ID, "error", err)
+	}
 	if d2 != nil {
 		Log.Debug("Found matching Day", "day", d2)
 		return d2.Target
@@ -365,13 +305,15 @@ func getTarget(db *bolt.DB, groupID string) string {
 }
 
 func getGroups(ctx *echo.Context) error {
-	list, err := allGroups(dbFromContext(ctx))
+	list, err := storeFromContext(ctx).Groups()
 	if err != nil {
 		return err
 	}
 	return ctx.JSON(200, list)
 }
 
+// postGroup adds the group given by the form fields `id` (which, if
+// empty, is generated), `name`, `timezone`, and `defaultTarget`
 func postGroup(ctx *echo.Context) error {
 	g := Group{
 		ID:            ctx.Form("id"),
@@ -382,16 +324,79 @@ func postGroup(ctx *echo.Context) error {
 	if g.ID == "" {
 		g.ID = uuid.NewV1().String()
 	}
-	return saveGroup(dbFromContext(ctx), &g)
+	return groupResponse(ctx, 201, &g, createGroup(storeFromContext(ctx), &g))
+}
+
+// editGroup updates the group with the fields given as form fields
+// or as JSON; fields which are not given are unchanged
+func editGroup(ctx *echo.Context) error {
+	id := ctx.Param("id")
+	u, bodyID, err := parseGroupUpdate(ctx.Request())
+	if err != nil {
+		return ctx.String(400, err.Error())
+	}
+	if bodyID != "" && bodyID != id {
+		return ctx.String(400, "The ID of a group cannot be changed")
+	}
+	g, err := updateGroup(storeFromContext(ctx), id, u)
+	return groupResponse(ctx, 200, g, err)
+}
+
+// parseGroupUpdate parses the GroupUpdate, and the group ID, if
+// given, from the JSON or form body of the request
+func parseGroupUpdate(req *http.Request) (*GroupUpdate, string, error) {
+	u := new(GroupUpdate)
+	if mediaType(req.Header.Get("Content-Type")) == "application/json" {
+		var body struct {
+			ID string `json:"id"`
+			GroupUpdate
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			return nil, "", errors.Wrap(err, "failed to parse group")
+		}
+		return &body.GroupUpdate, body.ID, nil
+	}
+
+	if err := req.ParseMultipartForm(1 << 20); err != nil && err != http.ErrNotMultipart {
+		return nil, "", errors.Wrap(err, "failed to parse group")
+	}
+	field := func(name string) *string {
+		if v, ok := req.Form[name]; ok && len(v) > 0 {
+			return &v[0]
+		}
+		return nil
+	}
+	u.Name = field("name")
+	u.Location = field("timezone")
+	u.DefaultTarget = field("defaultTarget")
+	return u, req.Form.Get("id"), nil
+}
+
+// groupResponse responds to a group request with the group, or
+// with the status for the error
+func groupResponse(ctx *echo.Context, code int, g *Group, err error) error {
+	switch err.(type) {
+	case nil:
+		return ctx.JSON(code, g)
+	case invalidGroupError:
+		return ctx.String(400, err.Error())
+	}
+	switch err {
+	case ErrNotFound:
+		return ctx.String(404, "Not found")
+	case ErrGroupExists:
+		return ctx.String(409, err.Error())
+	}
+	return ctx.String(500, err.Error())
 }
 
 func getGroupHandler(ctx *echo.Context) error {
-	g, err := getGroup(dbFromContext(ctx), ctx.Param("id"))
+	g, err := storeFromContext(ctx).Group(ctx.Param("id"))
 	if err != nil {
 		return err
 	}
 	return ctx.JSON(200, g)
 }
 func deleteGroupHandler(ctx *echo.Context) error {
-	return deleteGroup(dbFromContext(ctx), ctx.Param("id"))
+	return storeFromContext(ctx).DeleteGroup(ctx.Param("id"))
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGetTargetAt(t *testing.T) {
+	db, err := dbOpen("./targetTest.db")
+	if err != nil {
+		panic("Failed to open test database")
+	}
+	defer func() {
+		db.Close()
+		os.Remove("./targetTest.db")
+	}()
+
+	g := Group{
+		ID:            "testTargetGroup",
+		Name:          "testTargetGroup",
+		Location:      locString,
+		DefaultTarget: "100",
+	}
+	saveGroup(db, &g)
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		day := Day{
+			Group:    g.ID,
+			Target:   "200",
+			Day:      time.Saturday,
+			Start:    1 * time.Hour, // 01:00
+			Duration: 4 * time.Hour, // until 05:00
+			Location: g.Location,
+		}
+		if err := day.Save(tx); err != nil {
+			return err
+		}
+		date := Date{
+			Group:  g.ID,
+			Target: "300",
+			Date:   time.Date(2016, 01, 30, 1, 0, 0, 0, loc),
+			Time:   4 * time.Hour,
+		}
+		return date.Save(tx)
+	})
+	if err != nil {
+		t.Skip("Failed to write test data for TestGetTargetAt", err)
+		return
+	}
+
+	Convey("Given a group with a Saturday 01:00-05:00 Day, a Date on Jan 30, 2016 and a default target", t, func() {
+		Convey("At 02:00 Saturday, Jan 23, 2016 the Day target should be returned", func() {
+			So(getTarget(newBoltStore(db), g.ID, time.Date(2016, 01, 23, 2, 0, 0, 0, loc)), ShouldEqual, "200")
+		})
+		Convey("At 02:00 Saturday, Jan 30, 2016 the Date target should be returned", func() {
+			So(getTarget(newBoltStore(db), g.ID, time.Date(2016, 01, 30, 2, 0, 0, 0, loc)), ShouldEqual, "300")
+		})
+		Convey("At 12:00 Saturday, Jan 23, 2016 the default target should be returned", func() {
+			So(getTarget(newBoltStore(db), g.ID, time.Date(2016, 01, 23, 12, 0, 0, 0, loc)), ShouldEqual, "100")
+		})
+		Convey("An unknown group should have no target", func() {
+			So(getTarget(newBoltStore(db), "noSuchGroup", time.Date(2016, 01, 23, 2, 0, 0, 0, loc)), ShouldBeBlank)
+		})
+	})
+}
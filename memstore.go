@@ -0,0 +1,319 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryStore is a Store which holds everything in memory
+type memoryStore struct {
+	mu   *sync.RWMutex
+	data *memoryData
+}
+
+// memoryData is the content of a memoryStore
+type memoryData struct {
+	groups map[string]Group
+	days   map[string]map[string]Day  // by group, then key
+	dates  map[string]map[string]Date // by group, then key
+}
+
+// newMemoryStore returns an empty memoryStore
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		mu: new(sync.RWMutex),
+		data: &memoryData{
+			groups: make(map[string]Group),
+			days:   make(map[string]map[string]Day),
+			dates:  make(map[string]map[string]Date),
+		},
+	}
+}
+
+// clone returns a copy of the data
+func (m *memoryData) clone() *memoryData {
+	ret := &memoryData{
+		groups: make(map[string]Group, len(m.groups)),
+		days:   make(map[string]map[string]Day, len(m.days)),
+		dates:  make(map[string]map[string]Date, len(m.dates)),
+	}
+	for id, g := range m.groups {
+		ret.groups[id] = g
+	}
+	for id, days := range m.days {
+		ret.days[id] = make(map[string]Day, len(days))
+		for k, d := range days {
+			ret.days[id][k] = d
+		}
+	}
+	for id, dates := range m.dates {
+		ret.dates[id] = make(map[string]Date, len(dates))
+		for k, d := range dates {
+			ret.dates[id][k] = d
+		}
+	}
+	return ret
+}
+
+func (s *memoryStore) Groups() ([]*Group, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var ids []string
+	for id := range s.data.groups {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	list := []*Group{}
+	for _, id := range ids {
+		g := s.data.groups[id]
+		list = append(list, &g)
+	}
+	return list, nil
+}
+
+func (s *memoryStore) Group(id string) (*Group, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	g, ok := s.data.groups[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &g, nil
+}
+
+func (s *memoryStore) SaveGroup(g *Group) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.groups[g.ID] = *g
+	return nil
+}
+
+func (s *memoryStore) DeleteGroup(id string) error {
+	if id == "" {
+		return errEmptyID
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.data.groups, id)
+	delete(s.data.days, id)
+	delete(s.data.dates, id)
+	return nil
+}
+
+func (s *memoryStore) Days(g *Group) ([]Day, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	days := s.data.days[g.ID]
+	var keys []string
+	for k := range days {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var ret []Day
+	for _, k := range keys {
+		ret = append(ret, days[k])
+	}
+	return ret, nil
+}
+
+func (s *memoryStore) Dates(g *Group) ([]Date, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	dates := s.data.dates[g.ID]
+	var keys []string
+	for k := range dates {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var ret []Date
+	for _, k := range keys {
+		ret = append(ret, dates[k])
+	}
+	return ret, nil
+}
+
+func (s *memoryStore) ReplaceDays(g *Group, days []Day) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.data.days, g.ID)
+	s.mergeDays(g, days)
+	return nil
+}
+
+func (s *memoryStore) ReplaceDates(g *Group, dates []Date) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.data.dates, g.ID)
+	s.mergeDates(g, dates)
+	return nil
+}
+
+func (s *memoryStore) MergeDays(g *Group, days []Day) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.mergeDays(g, days)
+	return nil
+}
+
+func (s *memoryStore) MergeDates(g *Group, dates []Date) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.mergeDates(g, dates)
+	return nil
+}
+
+func (s *memoryStore) PutDay(g *Group, d Day) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.mergeDays(g, []Day{d})
+	return nil
+}
+
+func (s *memoryStore) DeleteDay(g *Group, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.data.days[g.ID][key]; !ok {
+		return ErrNotFound
+	}
+	delete(s.data.days[g.ID], key)
+	return nil
+}
+
+func (s *memoryStore) DatesBetween(g *Group, from, to time.Time) ([]Date, error) {
+	dates, err := s.Dates(g)
+	if err != nil {
+		return nil, err
+	}
+	return datesBetween(dates, from, to), nil
+}
+
+func (s *memoryStore) PutDate(g *Group, d Date) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.mergeDates(g, []Date{d})
+	return nil
+}
+
+func (s *memoryStore) DeleteDate(g *Group, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.data.dates[g.ID][key]; !ok {
+		return ErrNotFound
+	}
+	delete(s.data.dates[g.ID], key)
+	return nil
+}
+
+// mergeDays saves the Days to the group.  The lock must be held.
+func (s *memoryStore) mergeDays(g *Group, days []Day) {
+	if s.data.days[g.ID] == nil {
+		s.data.days[g.ID] = make(map[string]Day)
+	}
+	for _, d := range days {
+		d.Group = g.ID
+		s.data.days[g.ID][string(d.Key())] = d
+	}
+}
+
+// mergeDates saves the Dates to the group.  The lock must be held.
+func (s *memoryStore) mergeDates(g *Group, dates []Date) {
+	if s.data.dates[g.ID] == nil {
+		s.data.dates[g.ID] = make(map[string]Date)
+	}
+	for _, d := range dates {
+		d.Group = g.ID
+		s.data.dates[g.ID][string(d.Key())] = d
+	}
+}
+
+func (s *memoryStore) ActiveDay(g *Group, t time.Time) (*Day, error) {
+	days, err := s.Days(g)
+	if err != nil {
+		return nil, err
+	}
+	return activeDay(days, t), nil
+}
+
+func (s *memoryStore) ActiveDate(g *Group, t time.Time) (*Date, error) {
+	dates, err := s.Dates(g)
+	if err != nil {
+		return nil, err
+	}
+	return activeDate(dates, t), nil
+}
+
+func (s *memoryStore) ReplaceAll(schedules []*ScheduleDump) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data = newMemoryStore().data
+	for _, sd := range schedules {
+		s.data.groups[sd.Group.ID] = *sd.Group
+		s.mergeDays(sd.Group, sd.Days)
+		s.mergeDates(sd.Group, sd.Dates)
+	}
+	return nil
+}
+
+// PurgeOrphans deletes the Days and Dates kept for missing groups
+func (s *memoryStore) PurgeOrphans() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	orphans := make(map[string]bool)
+	for id := range s.data.days {
+		if _, ok := s.data.groups[id]; !ok {
+			orphans[id] = true
+		}
+	}
+	for id := range s.data.dates {
+		if _, ok := s.data.groups[id]; !ok {
+			orphans[id] = true
+		}
+	}
+
+	ids := []string{}
+	for id := range orphans {
+		ids = append(ids, id)
+		delete(s.data.days, id)
+		delete(s.data.dates, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Update runs fn against a copy of the data, which replaces the
+// data of the store if fn succeeds
+func (s *memoryStore) Update(fn func(s Store) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &memoryStore{mu: new(sync.RWMutex), data: s.data.clone()}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	s.data = tx.data
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// metaBucket is the name of the bucket which holds the
+// metadata of the database, such as its schema version
+var metaBucket = []byte("meta")
+
+// schemaVersionKey is the key, in the meta bucket, of the
+// schema version of the database
+var schemaVersionKey = []byte("schemaVersion")
+
+// reservedBuckets are the top-level buckets which are not
+// group buckets
+var reservedBuckets = [][]byte{groupBucket, daysBucket, datesBucket, metaBucket}
+
+// migration upgrades the database from the previous schema
+// version to Version
+type migration struct {
+	Version     int
+	Description string
+	Migrate     func(tx *bolt.Tx) error
+}
+
+// migrations are the migrations of the database schema, in order.
+// A database without a schema version is at version 0.  Once
+// released, a migration must never be changed; add another instead.
+var migrations = []migration{
+	{1, "Create the top-level buckets", createBuckets},
+	{2, "Zero-pad the minutes of Day keys", padDayKeys},
+	{3, "Convert records from gob to JSON", convertGobRecords},
+	{4, "Key Dates by their start time in UTC", rekeyDates},
+}
+
+// latestSchemaVersion returns the schema version of the database
+// once every migration has been run
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// getSchemaVersion returns the schema version of the database
+func getSchemaVersion(tx *bolt.Tx) (int, error) {
+	b := tx.Bucket(metaBucket)
+	if b == nil {
+		return 0, nil
+	}
+	v := b.Get(schemaVersionKey)
+	if v == nil {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(string(v))
+	if err != nil {
+		return 0, fmt.Errorf("Invalid schema version %q", v)
+	}
+	return version, nil
+}
+
+// setSchemaVersion records the schema version of the database
+func setSchemaVersion(tx *bolt.Tx, version int) error {
+	b, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return err
+	}
+	return b.Put(schemaVersionKey, []byte(strconv.Itoa(version)))
+}
+
+// checkSchemaVersion returns the schema version of the database,
+// which must not be newer than this version of ipc-schedule supports
+func checkSchemaVersion(tx *bolt.Tx) (int, error) {
+	version, err := getSchemaVersion(tx)
+	if err != nil {
+		return 0, err
+	}
+	if version > latestSchemaVersion() {
+		return 0, fmt.Errorf("Schema version %d is newer than this version of ipc-schedule supports (%d)", version, latestSchemaVersion())
+	}
+	return version, nil
+}
+
+// migrate runs each pending migration of the database, in order,
+// each within its own transaction
+func migrate(db *bolt.DB) error {
+	err := db.View(func(tx *bolt.Tx) error {
+		_, err := checkSchemaVersion(tx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		var ran bool
+		err := db.Update(func(tx *bolt.Tx) (err error) {
+			ran, err = runMigration(tx, m)
+			return
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to migrate database to schema version %d (%s): %s", m.Version, m.Description, err.Error())
+		}
+		if ran {
+			Log.Info("Migrated database", "version", m.Version, "migration", m.Description)
+		}
+	}
+	return nil
+}
+
+// migrateWithTx runs each pending migration of the database, in
+// order, within the given transaction
+func migrateWithTx(tx *bolt.Tx) error {
+	if _, err := checkSchemaVersion(tx); err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if _, err := runMigration(tx, m); err != nil {
+			return fmt.Errorf("Failed to migrate database to schema version %d (%s): %s", m.Version, m.Description, err.Error())
+		}
+	}
+	return nil
+}
+
+// runMigration runs the given migration, if the database is at
+// the previous schema version, reporting whether it was run
+func runMigration(tx *bolt.Tx, m migration) (bool, error) {
+	version, err := getSchemaVersion(tx)
+	if err != nil {
+		return false, err
+	}
+	if version >= m.Version {
+		return false, nil
+	}
+	if err = m.Migrate(tx); err != nil {
+		return false, err
+	}
+	return true, setSchemaVersion(tx, m.Version)
+}
+
+// isReservedBucket says whether the given top-level bucket is
+// not a group bucket
+func isReservedBucket(name []byte) bool {
+	for _, r := range reservedBuckets {
+		if bytes.Equal(name, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// padDayKeys rewrites the keys of every Day, from D:M (minutes since
+// midnight, unpadded) to D:MMMM, so that they sort in order of start
+// time and within MinDayKey and MaxDayKey
+func padDayKeys(tx *bolt.Tx) error {
+	return tx.ForEach(func(name []byte, g *bolt.Bucket) error {
+		if isReservedBucket(name) {
+			return nil
+		}
+		b := g.Bucket(daysBucket)
+		if b == nil {
+			return nil
+		}
+
+		rekeyed := make(map[string][]byte)
+		var old [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			var day, minutes int
+			if _, err := fmt.Sscanf(string(k), "%d:%d", &day, &minutes); err != nil {
+				return fmt.Errorf("Invalid day key %q in group %s", k, name)
+			}
+			rekeyed[fmt.Sprintf("%d:%04d", day, minutes)] = append([]byte(nil), v...)
+			old = append(old, append([]byte(nil), k...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range old {
+			if err = b.Delete(k); err != nil {
+				return err
+			}
+		}
+		for k, v := range rekeyed {
+			if err = b.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// convertGobRecords re-encodes, as JSON, every Group, Day, and
+// Date which is still gob-encoded
+func convertGobRecords(tx *bolt.Tx) error {
+	err := convertBucket(tx.Bucket(groupBucket), func(data []byte) ([]byte, error) {
+		var g Group
+		if err := decodeGroup(data, &g); err != nil {
+			return nil, err
+		}
+		return encodeGroup(&g)
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.ForEach(func(name []byte, g *bolt.Bucket) error {
+		if isReservedBucket(name) {
+			return nil
+		}
+		err := convertBucket(g.Bucket(daysBucket), func(data []byte) ([]byte, error) {
+			var d Day
+			if err := decodeDay(data, &d); err != nil {
+				return nil, err
+			}
+			return encodeDay(&d)
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to convert days of group %s: %s", name, err.Error())
+		}
+		err = convertBucket(g.Bucket(datesBucket), func(data []byte) ([]byte, error) {
+			var d Date
+			if err := decodeDate(data, &d); err != nil {
+				return nil, err
+			}
+			return encodeDate(&d)
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to convert dates of group %s: %s", name, err.Error())
+		}
+		return nil
+	})
+}
+
+// convertBucket replaces each gob-encoded value of the bucket, if
+// it exists, with its conversion
+func convertBucket(b *bolt.Bucket, convert func(data []byte) ([]byte, error)) error {
+	if b == nil {
+		return nil
+	}
+
+	converted := make(map[string][]byte)
+	err := b.ForEach(func(k, v []byte) error {
+		if v == nil || !isGobRecord(v) {
+			return nil
+		}
+		data, err := convert(v)
+		if err != nil {
+			return fmt.Errorf("Failed to convert %s: %s", k, err.Error())
+		}
+		converted[string(k)] = data
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for k, v := range converted {
+		if err = b.Put([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rekeyDates rewrites the keys of every Date, from the start time as
+// formatted by time.Time.String (which does not sort chronologically
+// across timezones) to TimeToDateKey, and records the duration of the
+// longest Date of each group
+func rekeyDates(tx *bolt.Tx) error {
+	return tx.ForEach(func(name []byte, g *bolt.Bucket) error {
+		if isReservedBucket(name) {
+			return nil
+		}
+		b := g.Bucket(datesBucket)
+		if b == nil {
+			return nil
+		}
+
+		rekeyed := make(map[string][]byte)
+		var old [][]byte
+		var span time.Duration
+		err := b.ForEach(func(k, v []byte) error {
+			var d Date
+			if err := decodeDate(v, &d); err != nil {
+				return fmt.Errorf("Failed to decode date %s of group %s: %s", k, name, err.Error())
+			}
+			if d.Time > span {
+				span = d.Time
+			}
+			rekeyed[string(d.Key())] = append([]byte(nil), v...)
+			old = append(old, append([]byte(nil), k...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range old {
+			if err = b.Delete(k); err != nil {
+				return err
+			}
+		}
+		for k, v := range rekeyed {
+			if err = b.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		return g.Put(dateSpanKey, []byte(span.String()))
+	})
+}
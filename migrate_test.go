@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// openLegacyDatabase opens a database without migrating it, with
+// gob-encoded Days at the given (unpadded) keys in group "a"
+func openLegacyDatabase(name string, dayKeys ...string) *bolt.DB {
+	db, err := bolt.Open(name, 0660, nil)
+	if err != nil {
+		panic("Failed to open test database")
+	}
+	db.Update(func(tx *bolt.Tx) error {
+		g, _ := tx.CreateBucketIfNotExists([]byte("a"))
+		days, _ := g.CreateBucketIfNotExists(daysBucket)
+		for _, k := range dayKeys {
+			var day, minutes int
+			fmt.Sscanf(k, "%d:%d", &day, &minutes)
+			d := Day{Group: "a", Target: k, Day: time.Weekday(day), Start: time.Duration(minutes) * time.Minute, Duration: time.Hour, Location: locString}
+			days.Put([]byte(k), gobEncode(&d))
+		}
+		return nil
+	})
+	return db
+}
+
+func gobEncode(v interface{}) []byte {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// dayKeys returns the key and target of each Day of the group
+func dayKeys(db *bolt.DB, group string) (keys []string) {
+	db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(group)).Bucket(daysBucket).ForEach(func(k, v []byte) error {
+			var d Day
+			if err := decodeDay(v, &d); err != nil {
+				return err
+			}
+			keys = append(keys, string(k)+"="+d.Target)
+			return nil
+		})
+	})
+	return
+}
+
+func schemaVersion(db *bolt.DB) (version int) {
+	db.View(func(tx *bolt.Tx) (err error) {
+		version, err = getSchemaVersion(tx)
+		return
+	})
+	return
+}
+
+func TestMigrations(t *testing.T) {
+	defer os.Remove("./migrateTest.db")
+
+	Convey("A new database should be at the latest schema version", t, func() {
+		db, err := dbOpen("./migrateTest.db")
+		So(err, ShouldBeNil)
+		defer func() {
+			db.Close()
+			os.Remove("./migrateTest.db")
+		}()
+
+		So(schemaVersion(db), ShouldEqual, latestSchemaVersion())
+		db.View(func(tx *bolt.Tx) error {
+			for _, name := range reservedBuckets {
+				So(tx.Bucket(name), ShouldNotBeNil)
+			}
+			return nil
+		})
+	})
+
+	Convey("Migration 1 should create the top-level buckets", t, func() {
+		db := openLegacyDatabase("./migrateTest.db")
+		defer func() {
+			db.Close()
+			os.Remove("./migrateTest.db")
+		}()
+
+		err := db.Update(func(tx *bolt.Tx) error {
+			ran, err := runMigration(tx, migrations[0])
+			So(ran, ShouldBeTrue)
+			return err
+		})
+		So(err, ShouldBeNil)
+		So(schemaVersion(db), ShouldEqual, 1)
+		db.View(func(tx *bolt.Tx) error {
+			So(tx.Bucket(groupBucket), ShouldNotBeNil)
+			So(tx.Bucket(daysBucket), ShouldNotBeNil)
+			So(tx.Bucket(datesBucket), ShouldNotBeNil)
+			return nil
+		})
+	})
+
+	Convey("Migration 2 should zero-pad the minutes of Day keys", t, func() {
+		db := openLegacyDatabase("./migrateTest.db", "1:600", "1:60", "6:0", "1:120")
+		defer func() {
+			db.Close()
+			os.Remove("./migrateTest.db")
+		}()
+
+		err := db.Update(func(tx *bolt.Tx) error {
+			if err := setSchemaVersion(tx, 1); err != nil {
+				return err
+			}
+			ran, err := runMigration(tx, migrations[1])
+			So(ran, ShouldBeTrue)
+			return err
+		})
+		So(err, ShouldBeNil)
+		So(schemaVersion(db), ShouldEqual, 2)
+		So(dayKeys(db, "a"), ShouldResemble, []string{
+			"1:0060=1:60",
+			"1:0120=1:120",
+			"1:0600=1:600",
+			"6:0000=6:0",
+		})
+
+		Convey("New days should be saved with the same keys", func() {
+			d := Day{Group: "a", Day: time.Monday, Start: time.Hour}
+			So(string(d.Key()), ShouldEqual, "1:0060")
+		})
+	})
+
+	Convey("Migration 3 should convert gob records to JSON", t, func() {
+		db := openLegacyDatabase("./migrateTest.db", "6:60")
+		defer func() {
+			db.Close()
+			os.Remove("./migrateTest.db")
+		}()
+
+		g := Group{ID: "a", Name: "A", Location: locString, DefaultTarget: "100"}
+		date := Date{Group: "a", Target: "300", Date: time.Date(2016, 03, 13, 1, 0, 0, 0, loc), Time: 4 * time.Hour}
+		err := db.Update(func(tx *bolt.Tx) error {
+			groups, _ := tx.CreateBucketIfNotExists(groupBucket)
+			groups.Put(g.Key(), gobEncode(&g))
+			dates, _ := tx.Bucket(g.Key()).CreateBucketIfNotExists(datesBucket)
+			dates.Put(date.Key(), gobEncode(&date))
+			if err := setSchemaVersion(tx, 2); err != nil {
+				return err
+			}
+			ran, err := runMigration(tx, migrations[2])
+			So(ran, ShouldBeTrue)
+			return err
+		})
+		So(err, ShouldBeNil)
+		So(schemaVersion(db), ShouldEqual, 3)
+
+		db.View(func(tx *bolt.Tx) error {
+			data := tx.Bucket(groupBucket).Get(g.Key())
+			So(isGobRecord(data), ShouldBeFalse)
+			var g2 Group
+			So(decodeGroup(data, &g2), ShouldBeNil)
+			So(g2, ShouldResemble, g)
+
+			data = tx.Bucket(g.Key()).Bucket(daysBucket).Get([]byte("6:60"))
+			So(isGobRecord(data), ShouldBeFalse)
+			So(string(data), ShouldContainSubstring, `"day":"Saturday"`)
+
+			data = tx.Bucket(g.Key()).Bucket(datesBucket).Get(date.Key())
+			So(isGobRecord(data), ShouldBeFalse)
+			var d2 Date
+			So(decodeDate(data, &d2), ShouldBeNil)
+			So(d2.Date.Equal(date.Date), ShouldBeTrue)
+			So(d2.Time, ShouldEqual, date.Time)
+			So(d2.Target, ShouldEqual, date.Target)
+			return nil
+		})
+	})
+
+	Convey("Migration 4 should key Dates by their start time in UTC", t, func() {
+		db := openLegacyDatabase("./migrateTest.db")
+		defer func() {
+			db.Close()
+			os.Remove("./migrateTest.db")
+		}()
+
+		pacific, _ := time.LoadLocation("US/Pacific")
+		dates := []Date{
+			Date{Group: "a", Target: "300", Date: time.Date(2016, 03, 13, 1, 0, 0, 0, loc), Time: 4 * time.Hour},
+			Date{Group: "a", Target: "301", Date: time.Date(2016, 03, 12, 23, 0, 0, 0, pacific), Time: 48 * time.Hour},
+			Date{Group: "a", Target: "302", Date: time.Date(2016, 03, 12, 9, 0, 0, 0, loc), Time: time.Hour},
+		}
+		err := db.Update(func(tx *bolt.Tx) error {
+			b, _ := tx.Bucket([]byte("a")).CreateBucketIfNotExists(datesBucket)
+			for i := range dates {
+				data, err := encodeDate(&dates[i])
+				if err != nil {
+					return err
+				}
+				b.Put([]byte(dates[i].Date.String()), data)
+			}
+			if err := setSchemaVersion(tx, 3); err != nil {
+				return err
+			}
+			ran, err := runMigration(tx, migrations[3])
+			So(ran, ShouldBeTrue)
+			return err
+		})
+		So(err, ShouldBeNil)
+		So(schemaVersion(db), ShouldEqual, 4)
+
+		db.View(func(tx *bolt.Tx) error {
+			g := tx.Bucket([]byte("a"))
+			var keys []string
+			g.Bucket(datesBucket).ForEach(func(k, v []byte) error {
+				var d Date
+				So(decodeDate(v, &d), ShouldBeNil)
+				keys = append(keys, string(k)+"="+d.Target)
+				return nil
+			})
+			So(keys, ShouldResemble, []string{
+				"2016-03-12T14:00:00.000000000Z=302",
+				"2016-03-13T06:00:00.000000000Z=300",
+				"2016-03-13T07:00:00.000000000Z=301",
+			})
+
+			span, ok := getDateSpan(g)
+			So(ok, ShouldBeTrue)
+			So(span, ShouldEqual, 48*time.Hour)
+			return nil
+		})
+	})
+
+	Convey("Records of a newer version should not be decoded", t, func() {
+		var g Group
+		So(decodeGroup([]byte(`{"v":1,"id":"a"}`), &g), ShouldBeNil)
+		So(decodeGroup([]byte(`{"v":2,"id":"a"}`), &g), ShouldNotBeNil)
+	})
+
+	Convey("Given an unversioned database", t, func() {
+		db := openLegacyDatabase("./migrateTest.db", "1:60", "1:600")
+		defer func() {
+			db.Close()
+			os.Remove("./migrateTest.db")
+		}()
+
+		Convey("Every migration should be run, once", func() {
+			So(migrate(db), ShouldBeNil)
+			So(schemaVersion(db), ShouldEqual, latestSchemaVersion())
+			keys := dayKeys(db, "a")
+			So(keys, ShouldResemble, []string{"1:0060=1:60", "1:0600=1:600"})
+
+			So(migrate(db), ShouldBeNil)
+			So(dayKeys(db, "a"), ShouldResemble, keys)
+		})
+	})
+
+	Convey("A database with a newer schema should be refused", t, func() {
+		db := openLegacyDatabase("./migrateTest.db")
+		defer func() {
+			db.Close()
+			os.Remove("./migrateTest.db")
+		}()
+
+		db.Update(func(tx *bolt.Tx) error {
+			return setSchemaVersion(tx, latestSchemaVersion()+1)
+		})
+		So(migrate(db), ShouldNotBeNil)
+		So(schemaVersion(db), ShouldEqual, latestSchemaVersion()+1)
+	})
+}
@@ -70,3 +70,32 @@ func parseTime(src string) (dur time.Duration, err error) {
 
 	return
 }
+
+// formatTime returns the HH:mm-formatted time of day which
+// is the given duration after midnight, wrapping at 24 hours.
+// Seconds, if any, are appended as HH:mm:ss.
+func formatTime(dur time.Duration) string {
+	dur %= 24 * time.Hour
+	if dur < 0 {
+		dur += 24 * time.Hour
+	}
+	h := int(dur / time.Hour)
+	m := int(dur % time.Hour / time.Minute)
+	if sec := int(dur % time.Minute / time.Second); sec != 0 {
+		return fmt.Sprintf("%02d:%02d:%02d", h, m, sec)
+	}
+	return fmt.Sprintf("%02d:%02d", h, m)
+}
+
+// parseAt parses the RFC3339 timestamp at which a schedule
+// should be resolved.  An empty string means now.
+func parseAt(src string) (time.Time, error) {
+	if src == "" {
+		return time.Now(), nil
+	}
+	t, err := time.Parse(time.RFC3339, src)
+	if err != nil {
+		return t, fmt.Errorf("Failed to parse %s as an RFC3339 timestamp", src)
+	}
+	return t, nil
+}
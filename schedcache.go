@@ -0,0 +1,231 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// scheduleCacheSpan is the range of time, from the time at which it
+// is compiled, covered by a compiled schedule
+const scheduleCacheSpan = 7 * 24 * time.Hour
+
+// scheduleCacheMargin is the range of time before the time at which
+// it is compiled, covered by a compiled schedule
+const scheduleCacheMargin = time.Hour
+
+// scheduleCacheTTL is how long a compiled schedule is used before
+// it is compiled again, so that changes made to the database by
+// other processes (as to a SQLite database) are seen
+var scheduleCacheTTL = time.Minute
+
+// compiledSchedule is the schedule of a group, resolved into
+// contiguous, chronological segments over a range of time
+type compiledSchedule struct {
+	from, to time.Time
+	expires  time.Time
+	segments []Segment
+}
+
+// lookup returns the target of the schedule at the given time, and
+// false if the time is outside the range of the schedule or within
+// a second of a change of target, where getTarget must decide.
+func (c *compiledSchedule) lookup(at time.Time) (string, bool) {
+	if at.Before(c.from) || !at.Before(c.to) {
+		return "", false
+	}
+	i := sort.Search(len(c.segments), func(i int) bool {
+		return c.segments[i].Stop.After(at)
+	})
+	if i == len(c.segments) {
+		return "", false
+	}
+	seg := c.segments[i]
+	if at.Sub(seg.Start) <= time.Second || seg.Stop.Sub(at) <= time.Second {
+		return "", false
+	}
+	return seg.Target, true
+}
+
+// targetCache is implemented by Stores which can resolve the target
+// of a group without reading the database
+type targetCache interface {
+	cachedTarget(groupID string, at time.Time) (string, bool)
+}
+
+// scheduleCache is a Store which keeps the compiled schedule of
+// each group in memory, so that getTarget is a lock-free lookup.
+// The compiled schedule of a group is dropped when the group or its
+// schedule is changed through the cache.
+type scheduleCache struct {
+	Store
+
+	// compiled is the map[string]*compiledSchedule of group ID to
+	// compiled schedule, which is replaced, never changed
+	compiled atomic.Value
+
+	// mu serializes the replacement of compiled
+	mu sync.Mutex
+
+	// generation is incremented whenever a compiled schedule is
+	// dropped, so that a schedule compiled from the database as it
+	// was before the change is not kept
+	generation uint64
+
+	// now returns the current time (time.Now, but for tests)
+	now func() time.Time
+}
+
+// newScheduleCache returns the scheduleCache for the given Store
+func newScheduleCache(s Store) *scheduleCache {
+	c := &scheduleCache{Store: s, now: time.Now}
+	c.compiled.Store(map[string]*compiledSchedule{})
+	return c
+}
+
+// cachedTarget returns the target of the group at the given time,
+// from the compiled schedule of the group, compiling it if need be.
+// Only the schedule around the current time is compiled, so that
+// lookups at other times (as by support queries) do not displace it.
+// It returns false if the target must be resolved by getTarget.
+func (c *scheduleCache) cachedTarget(groupID string, at time.Time) (string, bool) {
+	now := c.now()
+	if at.Before(now.Add(-scheduleCacheMargin)) || !at.Before(now.Add(scheduleCacheSpan)) {
+		return "", false
+	}
+	cs := c.compiled.Load().(map[string]*compiledSchedule)[groupID]
+	if cs == nil || now.After(cs.expires) || at.Before(cs.from) || !at.Before(cs.to) {
+		var err error
+		if cs, err = c.compile(groupID, now); err != nil {
+			Log.Debug("Failed to compile schedule", "group", groupID, "error", err)
+			return "", false
+		}
+	}
+	return cs.lookup(at)
+}
+
+// compile compiles the schedule of the group over the range
+// around the given (current) time, and keeps it unless the group was
+// changed meanwhile
+func (c *scheduleCache) compile(groupID string, at time.Time) (*compiledSchedule, error) {
+	gen := atomic.LoadUint64(&c.generation)
+
+	g, err := c.Store.Group(groupID)
+	if err != nil {
+		return nil, err
+	}
+	dates, err := c.Store.Dates(g)
+	if err != nil {
+		return nil, err
+	}
+	days, err := c.Store.Days(g)
+	if err != nil {
+		return nil, err
+	}
+
+	cs := &compiledSchedule{
+		from:    at.Add(-scheduleCacheMargin),
+		to:      at.Add(scheduleCacheSpan),
+		expires: c.now().Add(scheduleCacheTTL),
+	}
+	cs.segments = buildTimeline(g, dates, days, cs.from, cs.to)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if atomic.LoadUint64(&c.generation) == gen {
+		old := c.compiled.Load().(map[string]*compiledSchedule)
+		m := make(map[string]*compiledSchedule, len(old)+1)
+		for id, s := range old {
+			m[id] = s
+		}
+		m[groupID] = cs
+		c.compiled.Store(m)
+	}
+	return cs, nil
+}
+
+// invalidate drops the compiled schedule of the given group or, if
+// no group is given, of every group
+func (c *scheduleCache) invalidate(groupID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	atomic.AddUint64(&c.generation, 1)
+	m := make(map[string]*compiledSchedule)
+	if groupID != "" {
+		for id, s := range c.compiled.Load().(map[string]*compiledSchedule) {
+			if id != groupID {
+				m[id] = s
+			}
+		}
+	}
+	c.compiled.Store(m)
+}
+
+func (c *scheduleCache) SaveGroup(g *Group) error {
+	defer c.invalidate(g.ID)
+	return c.Store.SaveGroup(g)
+}
+
+func (c *scheduleCache) DeleteGroup(id string) error {
+	defer c.invalidate(id)
+	return c.Store.DeleteGroup(id)
+}
+
+func (c *scheduleCache) ReplaceDays(g *Group, days []Day) error {
+	defer c.invalidate(g.ID)
+	return c.Store.ReplaceDays(g, days)
+}
+
+func (c *scheduleCache) ReplaceDates(g *Group, dates []Date) error {
+	defer c.invalidate(g.ID)
+	return c.Store.ReplaceDates(g, dates)
+}
+
+func (c *scheduleCache) MergeDays(g *Group, days []Day) error {
+	defer c.invalidate(g.ID)
+	return c.Store.MergeDays(g, days)
+}
+
+func (c *scheduleCache) MergeDates(g *Group, dates []Date) error {
+	defer c.invalidate(g.ID)
+	return c.Store.MergeDates(g, dates)
+}
+
+func (c *scheduleCache) PutDay(g *Group, d Day) error {
+	defer c.invalidate(g.ID)
+	return c.Store.PutDay(g, d)
+}
+
+func (c *scheduleCache) DeleteDay(g *Group, key string) error {
+	defer c.invalidate(g.ID)
+	return c.Store.DeleteDay(g, key)
+}
+
+func (c *scheduleCache) PutDate(g *Group, d Date) error {
+	defer c.invalidate(g.ID)
+	return c.Store.PutDate(g, d)
+}
+
+func (c *scheduleCache) DeleteDate(g *Group, key string) error {
+	defer c.invalidate(g.ID)
+	return c.Store.DeleteDate(g, key)
+}
+
+func (c *scheduleCache) ReplaceAll(schedules []*ScheduleDump) error {
+	defer c.invalidate("")
+	return c.Store.ReplaceAll(schedules)
+}
+
+func (c *scheduleCache) PurgeOrphans() ([]string, error) {
+	defer c.invalidate("")
+	return c.Store.PurgeOrphans()
+}
+
+// Update drops every compiled schedule once fn returns, since fn
+// may change any group, through a Store which is not the cache
+func (c *scheduleCache) Update(fn func(s Store) error) error {
+	defer c.invalidate("")
+	return c.Store.Update(fn)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// cacheTestSchedule gives the group a week of Days, and Dates
+// around the change to daylight saving time
+func cacheTestSchedule(s Store, g *Group) error {
+	if err := s.SaveGroup(g); err != nil {
+		return err
+	}
+	var days []Day
+	for wd := time.Sunday; wd <= time.Saturday; wd++ {
+		days = append(days,
+			Day{Target: "200", Day: wd, Start: 8 * time.Hour, Duration: 9 * time.Hour, Location: g.Location},
+			Day{Target: "201", Day: wd, Start: 22 * time.Hour, Duration: 4 * time.Hour, Location: g.Location},
+		)
+	}
+	if err := s.ReplaceDays(g, days); err != nil {
+		return err
+	}
+	return s.ReplaceDates(g, []Date{
+		Date{Target: "300", Date: time.Date(2016, 03, 13, 1, 0, 0, 0, loc), Time: 4 * time.Hour},
+		Date{Target: "", Date: time.Date(2016, 03, 14, 12, 0, 0, 0, loc), Time: time.Hour},
+		Date{Target: "301", Date: time.Date(2016, 03, 15, 16, 30, 0, 0, loc), Time: 30 * time.Minute},
+	})
+}
+
+func TestScheduleCache(t *testing.T) {
+	db, err := dbOpen("./cacheTest.db")
+	if err != nil {
+		panic("Failed to open test database")
+	}
+	defer func() {
+		db.Close()
+		os.Remove("./cacheTest.db")
+	}()
+
+	g := &Group{ID: "testCacheGroup", Location: locString, DefaultTarget: "100"}
+	c := newScheduleCache(newBoltStore(db))
+	if err = cacheTestSchedule(c, g); err != nil {
+		panic("Failed to save test schedule")
+	}
+
+	// The compiled range, around the clock of the cache, covers the
+	// Dates and the change to daylight saving time
+	start := time.Date(2016, 03, 11, 0, 0, 0, 0, loc)
+	c.now = func() time.Time { return start }
+
+	Convey("Given a compiled schedule", t, func() {
+		Convey("Every lookup within the compiled range should agree with the database", func() {
+			// Clear of every change of target, which are on the minute
+			for at := start.Add(30 * time.Second); at.Before(start.Add(scheduleCacheSpan)); at = at.Add(7 * time.Minute) {
+				target, ok := c.cachedTarget(g.ID, at)
+				So(ok, ShouldBeTrue)
+				So(target, ShouldEqual, getTarget(c.Store, g.ID, at))
+			}
+		})
+
+		Convey("Lookups at a change of target should agree with the database", func() {
+			for _, at := range []time.Time{
+				time.Date(2016, 03, 12, 8, 0, 0, 0, loc),
+				time.Date(2016, 03, 12, 17, 0, 0, 0, loc),
+				time.Date(2016, 03, 13, 1, 0, 0, 0, loc),
+				time.Date(2016, 03, 13, 4, 0, 0, 0, loc),
+				time.Date(2016, 03, 14, 12, 0, 0, 0, loc),
+			} {
+				So(getTarget(c, g.ID, at), ShouldEqual, getTarget(c.Store, g.ID, at))
+			}
+		})
+
+		Convey("The Dates should be resolved from the compiled schedule", func() {
+			for at, want := range map[time.Time]string{
+				time.Date(2016, 03, 13, 3, 30, 0, 0, loc):  "300", // after the change to daylight saving time
+				time.Date(2016, 03, 14, 12, 30, 0, 0, loc): "200", // the Day, under the Date without a target
+				time.Date(2016, 03, 15, 16, 45, 0, 0, loc): "301",
+				time.Date(2016, 03, 15, 17, 15, 0, 0, loc): "100",
+			} {
+				target, ok := c.cachedTarget(g.ID, at)
+				So(ok, ShouldBeTrue)
+				So(target, ShouldEqual, want)
+			}
+		})
+
+		noon := time.Date(2016, 03, 12, 12, 0, 0, 0, loc)
+		early := time.Date(2016, 03, 16, 3, 0, 0, 0, loc)
+
+		Convey("Lookups within the compiled range should not read the database", func() {
+			target, ok := c.cachedTarget(g.ID, noon)
+			So(ok, ShouldBeTrue)
+			So(target, ShouldEqual, "200")
+			cs := c.compiled.Load().(map[string]*compiledSchedule)[g.ID]
+			So(cs, ShouldNotBeNil)
+			So(cs.from.After(start), ShouldBeFalse)
+
+			target, ok = c.cachedTarget(g.ID, noon.Add(24*time.Hour))
+			So(ok, ShouldBeTrue)
+			So(target, ShouldEqual, "200")
+			So(c.compiled.Load().(map[string]*compiledSchedule)[g.ID], ShouldEqual, cs)
+		})
+
+		Convey("Lookups outside the range around now should not displace the compiled schedule", func() {
+			_, ok := c.cachedTarget(g.ID, noon)
+			So(ok, ShouldBeTrue)
+			cs := c.compiled.Load().(map[string]*compiledSchedule)[g.ID]
+
+			for _, at := range []time.Time{start.Add(-2 * time.Hour), start.AddDate(0, 1, 0)} {
+				_, ok = c.cachedTarget(g.ID, at)
+				So(ok, ShouldBeFalse)
+				So(getTarget(c, g.ID, at), ShouldEqual, getTarget(c.Store, g.ID, at))
+			}
+			So(c.compiled.Load().(map[string]*compiledSchedule)[g.ID], ShouldEqual, cs)
+		})
+
+		Convey("Changing the group should drop its compiled schedule", func() {
+			at := early
+			target, ok := c.cachedTarget(g.ID, at)
+			So(ok, ShouldBeTrue)
+			So(target, ShouldEqual, "100")
+
+			g2 := *g
+			g2.DefaultTarget = "101"
+			So(c.SaveGroup(&g2), ShouldBeNil)
+			target, _ = c.cachedTarget(g.ID, at)
+			So(target, ShouldEqual, "101")
+
+			err := c.Update(func(s Store) error {
+				return s.MergeDays(g, []Day{Day{Target: "202", Day: at.Weekday(), Start: 2 * time.Hour, Duration: 2 * time.Hour, Location: g.Location}})
+			})
+			So(err, ShouldBeNil)
+			target, _ = c.cachedTarget(g.ID, at)
+			So(target, ShouldEqual, "202")
+
+			So(c.DeleteGroup(g.ID), ShouldBeNil)
+			So(getTarget(c, g.ID, at), ShouldEqual, "")
+
+			So(cacheTestSchedule(c, g), ShouldBeNil)
+			target, ok = c.cachedTarget(g.ID, at)
+			So(ok, ShouldBeTrue)
+			So(target, ShouldEqual, "100")
+		})
+	})
+}
+
+// benchmarkTarget resolves the target of a group with a week of
+// Days and a year of Dates through the given Store
+func benchmarkTarget(b *testing.B, wrap func(s Store) Store) {
+	db, err := dbOpen("./cacheBench.db")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() {
+		db.Close()
+		os.Remove("./cacheBench.db")
+	}()
+
+	g := &Group{ID: "benchmarkTargetGroup", Location: locString, DefaultTarget: "100"}
+	s := wrap(newBoltStore(db))
+	if err = cacheTestSchedule(s, g); err != nil {
+		b.Fatal(err)
+	}
+	var dates []Date
+	now := time.Now()
+	for t := now.AddDate(-1, 0, 0).Add(2 * time.Hour); t.Before(now.AddDate(0, 0, -1)); t = t.AddDate(0, 0, 1) {
+		dates = append(dates, Date{Target: "300", Date: t, Time: time.Hour})
+	}
+	if err = s.MergeDates(g, dates); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if getTarget(s, g.ID, time.Now()) == "" {
+			b.Fatal("no target")
+		}
+	}
+}
+
+func BenchmarkGetTarget(b *testing.B) {
+	benchmarkTarget(b, func(s Store) Store { return s })
+}
+
+func BenchmarkGetTargetCached(b *testing.B) {
+	benchmarkTarget(b, func(s Store) Store { return newScheduleCache(s) })
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqlSchemaVersion is the version of the SQLite schema, which is
+// recorded as the user_version of the database
+const sqlSchemaVersion = 2
+
+// sqlMigrations upgrade a database from the previous schema
+// version; sqlSchema then creates whatever they did not
+var sqlMigrations = map[int]string{
+	// The range indexes did not lead with the group, so lookups
+	// used the primary key instead
+	2: `DROP INDEX IF EXISTS days_weekday_start;
+DROP INDEX IF EXISTS dates_range;`,
+}
+
+// sqlSchema creates the tables and indexes of the SQLite database.
+// Times and durations are in seconds; the start of each date is
+// also kept as RFC3339, in its own timezone.  The primary keys
+// order the Days and Dates of each group; the longest Date of a
+// group, from dates_duration, bounds the Dates which may be active
+// at a time, as dateSpanKey does in BoltDB.
+const sqlSchema = `
+CREATE TABLE IF NOT EXISTS groups (
+	id             TEXT PRIMARY KEY,
+	name           TEXT NOT NULL DEFAULT '',
+	timezone       TEXT NOT NULL DEFAULT '',
+	default_target TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS days (
+	group_id TEXT NOT NULL,
+	weekday  INTEGER NOT NULL, -- 0 (Sunday) to 6 (Saturday)
+	start    INTEGER NOT NULL, -- seconds after midnight
+	duration INTEGER NOT NULL,
+	target   TEXT NOT NULL DEFAULT '',
+	timezone TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (group_id, weekday, start)
+);
+
+CREATE TABLE IF NOT EXISTS dates (
+	group_id   TEXT NOT NULL,
+	start_unix INTEGER NOT NULL,
+	end_unix   INTEGER NOT NULL,
+	start      TEXT NOT NULL, -- RFC3339
+	duration   INTEGER NOT NULL,
+	target     TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (group_id, start_unix)
+);
+CREATE INDEX IF NOT EXISTS dates_duration ON dates (group_id, duration);
+`
+
+// sqlBusyTimeout is how long a connection waits for a lock held
+// by another connection (or process) before failing
+var sqlBusyTimeout = 5 * time.Second
+
+// sqlQuerier is a database or a transaction
+type sqlQuerier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// sqlStore is the Store backed by a SQLite database
+type sqlStore struct {
+	db *sql.DB
+
+	// tx is the transaction of Update, if any
+	tx *sql.Tx
+}
+
+// sqlOpen opens (or creates) the SQLite database, creating its
+// tables.  The database is opened in WAL mode, so that readers
+// (such as the service and ad hoc queries) do not block each other.
+func sqlOpen(f string) (*sqlStore, error) {
+	// Ensure database path exists
+	if err := os.MkdirAll(path.Dir(f), 0770); err != nil {
+		return nil, err
+	}
+
+	dsn := fmt.Sprintf("file:%s?_busy_timeout=%d&_journal_mode=WAL", f, sqlBusyTimeout/time.Millisecond)
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	var version int
+	if err = db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if version > sqlSchemaVersion {
+		db.Close()
+		return nil, fmt.Errorf("SQLite schema version %d is newer than the latest supported version %d", version, sqlSchemaVersion)
+	}
+	s := &sqlStore{db: db}
+	err = s.update(func(q sqlQuerier) error {
+		// A new database (version 0) needs only the schema
+		for v := version + 1; version > 0 && v <= sqlSchemaVersion; v++ {
+			if _, err := q.Exec(sqlMigrations[v]); err != nil {
+				return fmt.Errorf("Failed to migrate SQLite schema to version %d: %s", v, err.Error())
+			}
+		}
+		if _, err := q.Exec(sqlSchema); err != nil {
+			return err
+		}
+		_, err := q.Exec(fmt.Sprintf("PRAGMA user_version = %d", sqlSchemaVersion))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// q returns the transaction of the store, if it has one, or else
+// the database
+func (s *sqlStore) q() sqlQuerier {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.db
+}
+
+// update runs fn within the transaction of the store, if it has
+// one, or else within a new transaction
+func (s *sqlStore) update(fn func(q sqlQuerier) error) error {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) Groups() ([]*Group, error) {
+	rows, err := s.q().Query("SELECT id, name, timezone, default_target FROM groups ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []*Group{}
+	for rows.Next() {
+		g := new(Group)
+		if err = rows.Scan(&g.ID, &g.Name, &g.Location, &g.DefaultTarget); err != nil {
+			return nil, err
+		}
+		list = append(list, g)
+	}
+	return list, rows.Err()
+}
+
+func (s *sqlStore) Group(id string) (*Group, error) {
+	g := new(Group)
+	err := s.q().QueryRow("SELECT id, name, timezone, default_target FROM groups WHERE id = ?", id).
+		Scan(&g.ID, &g.Name, &g.Location, &g.DefaultTarget)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func (s *sqlStore) SaveGroup(g *Group) error {
+	_, err := s.q().Exec("INSERT OR REPLACE INTO groups (id, name, timezone, default_target) VALUES (?, ?, ?, ?)",
+		g.ID, g.Name, g.Location, g.DefaultTarget)
+	return err
+}
+
+func (s *sqlStore) DeleteGroup(id string) error {
+	if id == "" {
+		return errEmptyID
+	}
+	return s.update(func(q sqlQuerier) error {
+		for _, table := range []string{"days", "dates"} {
+			if _, err := q.Exec("DELETE FROM "+table+" WHERE group_id = ?", id); err != nil {
+				return err
+			}
+		}
+		_, err := q.Exec("DELETE FROM groups WHERE id = ?", id)
+		return err
+	})
+}
+
+func (s *sqlStore) Days(g *Group) ([]Day, error) {
+	return queryDays(s.q(), g.ID)
+}
+
+func (s *sqlStore) Dates(g *Group) ([]Date, error) {
+	return queryDates(s.q(), "WHERE group_id = ?", g.ID)
+}
+
+func (s *sqlStore) ReplaceDays(g *Group, days []Day) error {
+	return s.update(func(q sqlQuerier) error {
+		if _, err := q.Exec("DELETE FROM days WHERE group_id = ?", g.ID); err != nil {
+			return err
+		}
+		return insertDays(q, g, days)
+	})
+}
+
+func (s *sqlStore) ReplaceDates(g *Group, dates []Date) error {
+	return s.update(func(q sqlQuerier) error {
+		if _, err := q.Exec("DELETE FROM dates WHERE group_id = ?", g.ID); err != nil {
+			return err
+		}
+		return insertDates(q, g, dates)
+	})
+}
+
+func (s *sqlStore) MergeDays(g *Group, days []Day) error {
+	return s.update(func(q sqlQuerier) error {
+		return insertDays(q, g, days)
+	})
+}
+
+func (s *sqlStore) MergeDates(g *Group, dates []Date) error {
+	return s.update(func(q sqlQuerier) error {
+		return insertDates(q, g, dates)
+	})
+}
+
+func (s *sqlStore) PutDay(g *Group, d Day) error {
+	return insertDays(s.q(), g, []Day{d})
+}
+
+// DeleteDay finds the Day among those of its weekday, since its key
+// gives its start only to the minute
+func (s *sqlStore) DeleteDay(g *Group, key string) error {
+	var weekday int
+	if _, err := fmt.Sscanf(key, "%d:", &weekday); err != nil {
+		return ErrNotFound
+	}
+	return s.update(func(q sqlQuerier) error {
+		rows, err := q.Query("SELECT start FROM days WHERE group_id = ? AND weekday = ?", g.ID, weekday)
+		if err != nil {
+			return err
+		}
+		start := int64(-1)
+		for rows.Next() {
+			var secs int64
+			if err = rows.Scan(&secs); err != nil {
+				rows.Close()
+				return err
+			}
+			d := Day{Day: time.Weekday(weekday), Start: time.Duration(secs) * time.Second}
+			if string(d.Key()) == key {
+				start = secs
+			}
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		if start < 0 {
+			return ErrNotFound
+		}
+		_, err = q.Exec("DELETE FROM days WHERE group_id = ? AND weekday = ? AND start = ?", g.ID, weekday, start)
+		return err
+	})
+}
+
+// DatesBetween selects, by primary key, the Dates which start
+// before to, and no earlier than the longest Date of the group
+// before from
+func (s *sqlStore) DatesBetween(g *Group, from, to time.Time) ([]Date, error) {
+	where := "WHERE group_id = ?"
+	args := []interface{}{g.ID}
+	if !from.IsZero() {
+		where += ` AND start_unix >= ? - (SELECT IFNULL(MAX(duration), 0) FROM dates WHERE group_id = ?)
+			AND end_unix >= ?`
+		args = append(args, from.Unix()-1, g.ID, from.Unix())
+	}
+	if !to.IsZero() {
+		where += " AND start_unix <= ?"
+		args = append(args, to.Unix())
+	}
+	dates, err := queryDates(s.q(), where, args...)
+	if err != nil {
+		return nil, err
+	}
+	return datesBetween(dates, from, to), nil
+}
+
+func (s *sqlStore) PutDate(g *Group, d Date) error {
+	return insertDates(s.q(), g, []Date{d})
+}
+
+func (s *sqlStore) DeleteDate(g *Group, key string) error {
+	start, err := time.Parse(dateKeyFormat, key)
+	if err != nil {
+		return ErrNotFound
+	}
+	res, err := s.q().Exec("DELETE FROM dates WHERE group_id = ? AND start_unix = ?", g.ID, start.Unix())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqlStore) ActiveDay(g *Group, t time.Time) (*Day, error) {
+	days, err := s.Days(g)
+	if err != nil {
+		return nil, err
+	}
+	return activeDay(days, t), nil
+}
+
+// ActiveDate selects, by primary key, the Dates which may be active
+// at the given time: those which start no earlier than the longest
+// Date of the group before it.  activeDate chooses among them.
+func (s *sqlStore) ActiveDate(g *Group, t time.Time) (*Date, error) {
+	dates, err := queryDates(s.q(), `WHERE group_id = ?
+		AND start_unix >= ? - (SELECT IFNULL(MAX(duration), 0) FROM dates WHERE group_id = ?)
+		AND start_unix <= ? AND end_unix >= ?`,
+		g.ID, t.Unix()-1, g.ID, t.Unix()+1, t.Unix()-1)
+	if err != nil {
+		return nil, err
+	}
+	return activeDate(dates, t), nil
+}
+
+func (s *sqlStore) ReplaceAll(schedules []*ScheduleDump) error {
+	return s.update(func(q sqlQuerier) error {
+		for _, table := range []string{"groups", "days", "dates"} {
+			if _, err := q.Exec("DELETE FROM " + table); err != nil {
+				return err
+			}
+		}
+		for _, sd := range schedules {
+			g := sd.Group
+			if _, err := q.Exec("INSERT INTO groups (id, name, timezone, default_target) VALUES (?, ?, ?, ?)",
+				g.ID, g.Name, g.Location, g.DefaultTarget); err != nil {
+				return err
+			}
+			if err := insertDays(q, g, sd.Days); err != nil {
+				return err
+			}
+			if err := insertDates(q, g, sd.Dates); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqlStore) PurgeOrphans() ([]string, error) {
+	ids := []string{}
+	err := s.update(func(q sqlQuerier) error {
+		rows, err := q.Query(`SELECT group_id FROM days WHERE group_id NOT IN (SELECT id FROM groups)
+			UNION SELECT group_id FROM dates WHERE group_id NOT IN (SELECT id FROM groups)
+			ORDER BY 1`)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id string
+			if err = rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		for _, table := range []string{"days", "dates"} {
+			if _, err = q.Exec("DELETE FROM " + table + " WHERE group_id NOT IN (SELECT id FROM groups)"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (s *sqlStore) Update(fn func(s Store) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err = fn(&sqlStore{db: s.db, tx: tx}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+// queryDays returns the Days of the group, ordered by weekday and
+// start time, as they are keyed in BoltDB
+func queryDays(q sqlQuerier, groupID string) ([]Day, error) {
+	rows, err := q.Query("SELECT group_id, target, weekday, start, duration, timezone FROM days WHERE group_id = ? ORDER BY weekday, start", groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ret []Day
+	for rows.Next() {
+		var d Day
+		var weekday int
+		var start, duration int64
+		if err = rows.Scan(&d.Group, &d.Target, &weekday, &start, &duration, &d.Location); err != nil {
+			return nil, err
+		}
+		d.Day = time.Weekday(weekday)
+		d.Start = time.Duration(start) * time.Second
+		d.Duration = time.Duration(duration) * time.Second
+		ret = append(ret, d)
+	}
+	return ret, rows.Err()
+}
+
+// queryDates returns the Dates matching the given WHERE clause,
+// ordered by start time
+func queryDates(q sqlQuerier, where string, args ...interface{}) ([]Date, error) {
+	rows, err := q.Query("SELECT group_id, target, start, duration FROM dates "+where+" ORDER BY start_unix", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ret []Date
+	for rows.Next() {
+		var d Date
+		var start string
+		var duration int64
+		if err = rows.Scan(&d.Group, &d.Target, &start, &duration); err != nil {
+			return nil, err
+		}
+		if d.Date, err = time.Parse(time.RFC3339, start); err != nil {
+			return nil, fmt.Errorf("Failed to parse start of date: %s", err.Error())
+		}
+		d.Time = time.Duration(duration) * time.Second
+		ret = append(ret, d)
+	}
+	return ret, rows.Err()
+}
+
+// insertDays saves each of the Days to the group, replacing those
+// with the same weekday and start time
+func insertDays(q sqlQuerier, g *Group, days []Day) error {
+	for _, d := range days {
+		_, err := q.Exec("INSERT OR REPLACE INTO days (group_id, weekday, start, duration, target, timezone) VALUES (?, ?, ?, ?, ?, ?)",
+			g.ID, int(d.Day), int64(d.Start/time.Second), int64(d.Duration/time.Second), d.Target, d.Location)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertDates saves each of the Dates to the group, replacing those
+// with the same start time
+func insertDates(q sqlQuerier, g *Group, dates []Date) error {
+	for _, d := range dates {
+		_, err := q.Exec("INSERT OR REPLACE INTO dates (group_id, start_unix, end_unix, start, duration, target) VALUES (?, ?, ?, ?, ?, ?)",
+			g.ID, d.Date.Unix(), d.Date.Add(d.Time).Unix(), d.Date.Format(time.RFC3339), int64(d.Time/time.Second), d.Target)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
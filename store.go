@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// Store is the storage of groups and their schedules.  The BoltDB
+// database (boltStore) is the default Store of the service, and a
+// SQLite database (sqlStore) may be used instead; memoryStore holds
+// everything in memory, for tests.
+type Store interface {
+	// Groups returns every group, ordered by ID
+	Groups() ([]*Group, error)
+
+	// Group returns the group with the given ID, or ErrNotFound
+	Group(id string) (*Group, error)
+
+	// SaveGroup adds the group, or replaces the group with the
+	// same ID
+	SaveGroup(g *Group) error
+
+	// DeleteGroup deletes the group with the given ID, and its
+	// schedule
+	DeleteGroup(id string) error
+
+	// Days returns the Days of the group, ordered by key.  A group
+	// without a schedule has no Days.
+	Days(g *Group) ([]Day, error)
+
+	// Dates returns the Dates of the group, ordered by key.  A
+	// group without a schedule has no Dates.
+	Dates(g *Group) ([]Date, error)
+
+	// ReplaceDays replaces every Day of the group with the given
+	// Days
+	ReplaceDays(g *Group, days []Day) error
+
+	// ReplaceDates replaces every Date of the group with the given
+	// Dates
+	ReplaceDates(g *Group, dates []Date) error
+
+	// MergeDays saves the given Days to the group, replacing only
+	// those with the same key (day of the week and start time)
+	MergeDays(g *Group, days []Day) error
+
+	// MergeDates saves the given Dates to the group, replacing only
+	// those with the same key (start time)
+	MergeDates(g *Group, dates []Date) error
+
+	// PutDay saves the Day to the group, replacing only the Day
+	// with the same key
+	PutDay(g *Group, d Day) error
+
+	// DeleteDay deletes the Day with the given key from the group,
+	// or returns ErrNotFound
+	DeleteDay(g *Group, key string) error
+
+	// DatesBetween returns the Dates of the group which end after
+	// from and start before to, ordered by key.  A zero time leaves
+	// that end of the range open.
+	DatesBetween(g *Group, from, to time.Time) ([]Date, error)
+
+	// PutDate saves the Date to the group, replacing only the Date
+	// with the same key
+	PutDate(g *Group, d Date) error
+
+	// DeleteDate deletes the Date with the given key from the
+	// group, or returns ErrNotFound
+	DeleteDate(g *Group, key string) error
+
+	// ActiveDay returns the first Day of the group which is active
+	// at the given time, or nil if there is none
+	ActiveDay(g *Group, t time.Time) (*Day, error)
+
+	// ActiveDate returns the first Date of the group which is
+	// active at the given time, or nil if there is none
+	ActiveDate(g *Group, t time.Time) (*Date, error)
+
+	// ReplaceAll replaces every group, and the schedule of every
+	// group, with the given schedules
+	ReplaceAll(schedules []*ScheduleDump) error
+
+	// PurgeOrphans deletes every schedule whose group does not
+	// exist, as left by the deletion of groups before schedules were
+	// deleted with them, returning the IDs of the missing groups
+	PurgeOrphans() ([]string, error)
+
+	// Update calls fn with a Store whose changes are made at once,
+	// if fn returns nil, or not at all, if it returns an error,
+	// which Update returns
+	Update(fn func(s Store) error) error
+
+	// Close closes the store
+	Close() error
+}
+
+// errEmptyID indicates an attempt to delete a group without an ID
+var errEmptyID = errors.New("Cannot delete nothing")
+
+// storeFromContext returns the Store from the echo.Context
+func storeFromContext(ctx *echo.Context) Store {
+	return ctx.Get("store").(Store)
+}
+
+// activeDay returns the first of the Days which is active at the
+// given time and has a target, or nil if there is none
+func activeDay(days []Day, t time.Time) *Day {
+	for i := range days {
+		if days[i].ActiveAt(t) {
+			Log.Debug("Day is active", "day", days[i])
+			if days[i].Target == "" {
+				return nil
+			}
+			return &days[i]
+		}
+	}
+	return nil
+}
+
+// datesBetween returns those of the Dates which end after from and
+// start before to; a zero time leaves that end of the range open
+func datesBetween(dates []Date, from, to time.Time) []Date {
+	var ret []Date
+	for _, d := range dates {
+		if !from.IsZero() && !d.Date.Add(d.Time).After(from) {
+			continue
+		}
+		if !to.IsZero() && !d.Date.Before(to) {
+			continue
+		}
+		ret = append(ret, d)
+	}
+	return ret
+}
+
+// activeDate returns the first of the Dates which is active at the
+// given time and has a target, or nil if there is none
+func activeDate(dates []Date, t time.Time) *Date {
+	for i := range dates {
+		if dates[i].ActiveAt(t) {
+			if dates[i].Target == "" {
+				return nil
+			}
+			return &dates[i]
+		}
+	}
+	return nil
+}
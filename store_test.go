@@ -0,0 +1,358 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testStore checks that the Store behaves as every Store must
+// testStore tests the Store; orphan deletes the group with the
+// given ID, but not its schedule, from the underlying database
+func testStore(t *testing.T, name string, s Store, orphan func(id string) error) {
+	g := &Group{ID: "testStoreGroup", Name: "testStoreGroup", Location: locString, DefaultTarget: "100"}
+	monday := Day{Target: "200", Day: time.Monday, Start: 9 * time.Hour, Duration: 8 * time.Hour, Location: locString}
+	tuesday := Day{Target: "201", Day: time.Tuesday, Start: 9 * time.Hour, Duration: 8 * time.Hour, Location: locString}
+	date := Date{Target: "300", Date: time.Date(2016, 01, 25, 12, 0, 0, 0, loc), Time: time.Hour}
+
+	Convey("Given a "+name, t, func() {
+		So(s.SaveGroup(g), ShouldBeNil)
+
+		Convey("The group should be found", func() {
+			got, err := s.Group(g.ID)
+			So(err, ShouldBeNil)
+			So(got, ShouldResemble, g)
+
+			list, err := s.Groups()
+			So(err, ShouldBeNil)
+			So(list, ShouldHaveLength, 1)
+
+			_, err = s.Group("noSuchGroup")
+			So(err, ShouldEqual, ErrNotFound)
+		})
+
+		Convey("A group without a schedule should have no days or dates", func() {
+			days, err := s.Days(g)
+			So(err, ShouldBeNil)
+			So(days, ShouldBeEmpty)
+			dates, err := s.Dates(g)
+			So(err, ShouldBeNil)
+			So(dates, ShouldBeEmpty)
+		})
+
+		Convey("Replacing and merging days should keep the right days", func() {
+			So(s.ReplaceDays(g, []Day{monday}), ShouldBeNil)
+			So(s.MergeDays(g, []Day{tuesday}), ShouldBeNil)
+			days, err := s.Days(g)
+			So(err, ShouldBeNil)
+			So(days, ShouldHaveLength, 2)
+			So(days[0].Group, ShouldEqual, g.ID)
+
+			So(s.ReplaceDays(g, []Day{tuesday}), ShouldBeNil)
+			days, err = s.Days(g)
+			So(err, ShouldBeNil)
+			So(days, ShouldHaveLength, 1)
+			So(days[0].Day, ShouldEqual, time.Tuesday)
+		})
+
+		Convey("Putting and deleting a single day should leave the others", func() {
+			So(s.ReplaceDays(g, []Day{monday}), ShouldBeNil)
+			So(s.PutDay(g, tuesday), ShouldBeNil)
+			later := monday
+			later.Target = "202"
+			So(s.PutDay(g, later), ShouldBeNil)
+			days, err := s.Days(g)
+			So(err, ShouldBeNil)
+			So(days, ShouldHaveLength, 2)
+			So(days[0].Target, ShouldEqual, "202")
+			So(days[1].Group, ShouldEqual, g.ID)
+
+			So(s.DeleteDay(g, string(monday.Key())), ShouldBeNil)
+			days, err = s.Days(g)
+			So(err, ShouldBeNil)
+			So(days, ShouldHaveLength, 1)
+			So(days[0].Day, ShouldEqual, time.Tuesday)
+			So(s.DeleteDay(g, string(monday.Key())), ShouldEqual, ErrNotFound)
+			So(s.DeleteDay(g, "noSuchDay"), ShouldEqual, ErrNotFound)
+		})
+
+		Convey("Putting and deleting a single date should leave the others", func() {
+			later := Date{Target: "301", Date: date.Date.AddDate(0, 0, 7), Time: 2 * time.Hour}
+			So(s.ReplaceDates(g, []Date{date}), ShouldBeNil)
+			So(s.PutDate(g, later), ShouldBeNil)
+			dates, err := s.Dates(g)
+			So(err, ShouldBeNil)
+			So(dates, ShouldHaveLength, 2)
+			So(dates[1].Group, ShouldEqual, g.ID)
+
+			dates, err = s.DatesBetween(g, date.Date.Add(date.Time), time.Time{})
+			So(err, ShouldBeNil)
+			So(dates, ShouldHaveLength, 1)
+			So(dates[0].Target, ShouldEqual, "301")
+			dates, err = s.DatesBetween(g, date.Date.Add(30*time.Minute), later.Date)
+			So(err, ShouldBeNil)
+			So(dates, ShouldHaveLength, 1)
+			So(dates[0].Target, ShouldEqual, "300")
+			dates, err = s.DatesBetween(g, time.Time{}, time.Time{})
+			So(err, ShouldBeNil)
+			So(dates, ShouldHaveLength, 2)
+
+			So(s.DeleteDate(g, string(date.Key())), ShouldBeNil)
+			dates, err = s.Dates(g)
+			So(err, ShouldBeNil)
+			So(dates, ShouldHaveLength, 1)
+			So(dates[0].Target, ShouldEqual, "301")
+			So(s.DeleteDate(g, string(date.Key())), ShouldEqual, ErrNotFound)
+			So(s.DeleteDate(g, "noSuchDate"), ShouldEqual, ErrNotFound)
+		})
+
+		Convey("The active day and date should be found", func() {
+			So(s.ReplaceDays(g, []Day{monday}), ShouldBeNil)
+			So(s.ReplaceDates(g, []Date{date}), ShouldBeNil)
+
+			d, err := s.ActiveDay(g, time.Date(2016, 01, 25, 12, 0, 0, 0, loc))
+			So(err, ShouldBeNil)
+			So(d, ShouldNotBeNil)
+			So(d.Target, ShouldEqual, "200")
+			d, err = s.ActiveDay(g, time.Date(2016, 01, 26, 12, 0, 0, 0, loc))
+			So(err, ShouldBeNil)
+			So(d, ShouldBeNil)
+
+			dt, err := s.ActiveDate(g, time.Date(2016, 01, 25, 12, 30, 0, 0, loc))
+			So(err, ShouldBeNil)
+			So(dt, ShouldNotBeNil)
+			So(dt.Target, ShouldEqual, "300")
+
+			So(getTarget(s, g.ID, time.Date(2016, 01, 25, 12, 30, 0, 0, loc)), ShouldEqual, "300")
+			So(getTarget(s, g.ID, time.Date(2016, 01, 25, 14, 0, 0, 0, loc)), ShouldEqual, "200")
+			So(getTarget(s, g.ID, time.Date(2016, 01, 26, 14, 0, 0, 0, loc)), ShouldEqual, "100")
+		})
+
+		Convey("A failed Update should change nothing", func() {
+			So(s.ReplaceDays(g, []Day{monday}), ShouldBeNil)
+			failed := errors.New("failed")
+			err := s.Update(func(tx Store) error {
+				if err := tx.ReplaceDays(g, []Day{tuesday}); err != nil {
+					return err
+				}
+				days, err := tx.Days(g)
+				So(err, ShouldBeNil)
+				So(days[0].Day, ShouldEqual, time.Tuesday)
+				return failed
+			})
+			So(err, ShouldEqual, failed)
+			days, err := s.Days(g)
+			So(err, ShouldBeNil)
+			So(days, ShouldHaveLength, 1)
+			So(days[0].Day, ShouldEqual, time.Monday)
+		})
+
+		Convey("A successful Update should change everything", func() {
+			err := s.Update(func(tx Store) error {
+				if err := tx.ReplaceDays(g, []Day{tuesday}); err != nil {
+					return err
+				}
+				return tx.SaveGroup(&Group{ID: "testStoreOther", Location: locString})
+			})
+			So(err, ShouldBeNil)
+			days, err := s.Days(g)
+			So(err, ShouldBeNil)
+			So(days[0].Day, ShouldEqual, time.Tuesday)
+			_, err = s.Group("testStoreOther")
+			So(err, ShouldBeNil)
+			So(s.DeleteGroup("testStoreOther"), ShouldBeNil)
+		})
+
+		Convey("Replacing everything should leave only the given schedules", func() {
+			So(s.ReplaceDays(g, []Day{monday}), ShouldBeNil)
+			other := &Group{ID: "testStoreOther", Location: locString}
+			So(s.ReplaceAll([]*ScheduleDump{
+				&ScheduleDump{Group: other, Days: []Day{tuesday}, Dates: []Date{date}},
+				&ScheduleDump{Group: g},
+			}), ShouldBeNil)
+
+			list, err := s.Groups()
+			So(err, ShouldBeNil)
+			So(list, ShouldHaveLength, 2)
+			So(list[0].ID, ShouldEqual, g.ID)
+			days, err := s.Days(g)
+			So(err, ShouldBeNil)
+			So(days, ShouldBeEmpty)
+			days, err = s.Days(other)
+			So(err, ShouldBeNil)
+			So(days, ShouldHaveLength, 1)
+			dates, err := s.Dates(other)
+			So(err, ShouldBeNil)
+			So(dates, ShouldHaveLength, 1)
+			So(dates[0].Date.Equal(date.Date), ShouldBeTrue)
+			So(dates[0].Time, ShouldEqual, date.Time)
+
+			So(s.ReplaceAll([]*ScheduleDump{&ScheduleDump{Group: g}}), ShouldBeNil)
+		})
+
+		Convey("A deleted group should not be found", func() {
+			So(s.DeleteGroup(g.ID), ShouldBeNil)
+			_, err := s.Group(g.ID)
+			So(err, ShouldEqual, ErrNotFound)
+			So(s.DeleteGroup(""), ShouldNotBeNil)
+		})
+
+		Convey("A deleted group should not keep its schedule", func() {
+			So(s.ReplaceDays(g, []Day{monday}), ShouldBeNil)
+			So(s.ReplaceDates(g, []Date{date}), ShouldBeNil)
+			So(s.DeleteGroup(g.ID), ShouldBeNil)
+
+			So(s.SaveGroup(g), ShouldBeNil)
+			days, err := s.Days(g)
+			So(err, ShouldBeNil)
+			So(days, ShouldBeEmpty)
+			dates, err := s.Dates(g)
+			So(err, ShouldBeNil)
+			So(dates, ShouldBeEmpty)
+		})
+
+		Convey("Orphaned schedules should be purged", func() {
+			other := &Group{ID: "testStoreOrphan", Location: locString}
+			So(s.SaveGroup(other), ShouldBeNil)
+			So(s.ReplaceDays(other, []Day{monday}), ShouldBeNil)
+			So(s.ReplaceDates(other, []Date{date}), ShouldBeNil)
+			So(s.ReplaceDays(g, []Day{tuesday}), ShouldBeNil)
+			So(orphan(other.ID), ShouldBeNil)
+
+			ids, err := s.PurgeOrphans()
+			So(err, ShouldBeNil)
+			So(ids, ShouldResemble, []string{other.ID})
+
+			So(s.SaveGroup(other), ShouldBeNil)
+			days, _ := s.Days(other)
+			So(days, ShouldBeEmpty)
+			dates, _ := s.Dates(other)
+			So(dates, ShouldBeEmpty)
+			days, _ = s.Days(g)
+			So(days, ShouldHaveLength, 1)
+			So(s.DeleteGroup(other.ID), ShouldBeNil)
+
+			ids, err = s.PurgeOrphans()
+			So(err, ShouldBeNil)
+			So(ids, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+	s := newMemoryStore()
+	testStore(t, "memory store", s, func(id string) error {
+		delete(s.data.groups, id)
+		return nil
+	})
+}
+
+func TestBoltStore(t *testing.T) {
+	db, err := dbOpen("./storeTest.db")
+	if err != nil {
+		panic("Failed to open test database")
+	}
+	defer func() {
+		db.Close()
+		os.Remove("./storeTest.db")
+	}()
+
+	testStore(t, "Bolt store", newBoltStore(db), func(id string) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(groupBucket).Delete([]byte(id))
+		})
+	})
+}
+
+func TestSQLStore(t *testing.T) {
+	s, err := sqlOpen("./storeTest.sqlite")
+	if err != nil {
+		panic("Failed to open test database")
+	}
+	defer func() {
+		s.Close()
+		os.Remove("./storeTest.sqlite")
+		os.Remove("./storeTest.sqlite-wal")
+		os.Remove("./storeTest.sqlite-shm")
+	}()
+
+	testStore(t, "SQLite store", s, func(id string) error {
+		_, err := s.db.Exec("DELETE FROM groups WHERE id = ?", id)
+		return err
+	})
+}
+
+func TestSQLSchemaMigration(t *testing.T) {
+	Convey("Given a SQLite database of schema version 1", t, func() {
+		db, err := sql.Open("sqlite3", "./migrateTest.sqlite")
+		So(err, ShouldBeNil)
+		_, err = db.Exec(`CREATE TABLE dates (group_id TEXT NOT NULL, start_unix INTEGER NOT NULL, end_unix INTEGER NOT NULL,
+			start TEXT NOT NULL, duration INTEGER NOT NULL, target TEXT NOT NULL DEFAULT '', PRIMARY KEY (group_id, start_unix));
+			CREATE INDEX dates_range ON dates (start_unix, end_unix);
+			PRAGMA user_version = 1;`)
+		So(err, ShouldBeNil)
+		db.Close()
+		defer func() {
+			os.Remove("./migrateTest.sqlite")
+			os.Remove("./migrateTest.sqlite-wal")
+			os.Remove("./migrateTest.sqlite-shm")
+		}()
+
+		Convey("Opening it should replace its indexes", func() {
+			s, err := sqlOpen("./migrateTest.sqlite")
+			So(err, ShouldBeNil)
+			defer s.Close()
+
+			var version int
+			So(s.db.QueryRow("PRAGMA user_version").Scan(&version), ShouldBeNil)
+			So(version, ShouldEqual, sqlSchemaVersion)
+
+			var n int
+			So(s.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'dates_range'").Scan(&n), ShouldBeNil)
+			So(n, ShouldEqual, 0)
+			So(s.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'dates_duration'").Scan(&n), ShouldBeNil)
+			So(n, ShouldEqual, 1)
+		})
+	})
+}
+
+// BenchmarkActiveDateSQL looks up the active Date among five years
+// of Dates of a group in a SQLite database
+func BenchmarkActiveDateSQL(b *testing.B) {
+	s, err := sqlOpen("./storeBench.sqlite")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() {
+		s.Close()
+		os.Remove("./storeBench.sqlite")
+		os.Remove("./storeBench.sqlite-wal")
+		os.Remove("./storeBench.sqlite-shm")
+	}()
+
+	g := &Group{ID: "benchmarkActiveDateSQL", Location: locString}
+	var dates []Date
+	start := time.Date(2016, 01, 01, 9, 0, 0, 0, loc)
+	for t := start; t.Before(start.AddDate(5, 0, 0)); t = t.AddDate(0, 0, 1) {
+		dates = append(dates, Date{Target: "300", Date: t, Time: 8 * time.Hour})
+	}
+	if err = s.SaveGroup(g); err != nil {
+		b.Fatal(err)
+	}
+	if err = s.ReplaceDates(g, dates); err != nil {
+		b.Fatal(err)
+	}
+
+	at := time.Date(2018, 06, 15, 12, 0, 0, 0, loc)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if d, _ := s.ActiveDate(g, at); d == nil {
+			b.Fatal("no active date")
+		}
+	}
+}
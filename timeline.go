@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// defaultTimelineSpan is the length of a timeline when no
+// stop time is requested
+const defaultTimelineSpan = 7 * 24 * time.Hour
+
+// maxTimelineSpan is the longest timeline which may be requested
+const maxTimelineSpan = 366 * 24 * time.Hour
+
+// Interval is a span of time
+type Interval struct {
+	Start time.Time `json:"start"`
+	Stop  time.Time `json:"stop"`
+}
+
+// Segment is a span of time during which a single target,
+// supplied by a single layer of the schedule, is in effect
+type Segment struct {
+	Start  time.Time `json:"start"`
+	Stop   time.Time `json:"stop"`
+	Target string    `json:"target"`
+	Source string    `json:"source"` // date, day, default, or none
+}
+
+// Timeline is the resolved schedule of a group over a range
+// of time
+type Timeline struct {
+	Group    string    `json:"group"`
+	Timezone string    `json:"timezone"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Segments []Segment `json:"segments"`
+}
+
+// getTimelineHandler returns the timeline for a group over the
+// range given by the optional RFC3339 `from` and `to` parameters
+// (defaulting to now and one week from `from`).
+func getTimelineHandler(ctx *echo.Context) error {
+	from, to, err := parseRange(ctx.Query("from"), ctx.Query("to"), defaultTimelineSpan)
+	if err != nil {
+		return ctx.String(400, err.Error())
+	}
+	if to.Sub(from) > maxTimelineSpan {
+		return ctx.String(400, fmt.Sprintf("Range may not exceed %s", maxTimelineSpan))
+	}
+
+	tl, err := getTimeline(storeFromContext(ctx), ctx.Param("id"), from, to)
+	if err != nil {
+		if err == ErrNotFound {
+			return ctx.String(404, "Not found")
+		}
+		return ctx.String(500, err.Error())
+	}
+	return ctx.JSON(200, tl)
+}
+
+// parseRange parses the RFC3339 bounds of a range of time.  An
+// empty `from` means now and an empty `to` means `span` after `from`.
+func parseRange(fromSrc, toSrc string, span time.Duration) (from, to time.Time, err error) {
+	if from, err = parseAt(fromSrc); err != nil {
+		return
+	}
+	if toSrc == "" {
+		to = from.Add(span)
+		return
+	}
+	if to, err = parseAt(toSrc); err != nil {
+		return
+	}
+	if !to.After(from) {
+		err = fmt.Errorf("Range must end after it starts")
+	}
+	return
+}
+
+// getTimeline loads the schedule for the group and resolves it
+// into a timeline over the given range
+func getTimeline(s Store, groupID string, from, to time.Time) (*Timeline, error) {
+	g, err := s.Group(groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	dates, err := s.Dates(g)
+	if err != nil {
+		return nil, err
+	}
+	days, err := s.Days(g)
+	if err != nil {
+		return nil, err
+	}
+
+	if loc, err := g.GetLocation(); err == nil {
+		from = from.In(loc)
+		to = to.In(loc)
+	}
+
+	return &Timeline{
+		Group:    g.ID,
+		Timezone: g.Location,
+		From:     from,
+		To:       to,
+		Segments: buildTimeline(g, dates, days, from, to),
+	}, nil
+}
+
+// buildTimeline flattens the schedule into a contiguous list of
+// segments covering the given range.  Each segment is resolved with
+// the same precedence as getTarget:  Date, then Day, then the
+// group's default target.
+func buildTimeline(g *Group, dates []Date, days []Day, from, to time.Time) []Segment {
+	dates = datesInRange(dates, from, to)
+	bounds := scheduleBounds(dates, days, from, to)
+
+	segments := []Segment{}
+	for i := 1; i < len(bounds); i++ {
+		start, stop := bounds[i-1], bounds[i]
+		if !stop.After(start) {
+			continue
+		}
+
+		// The resolution is constant between bounds, so sample the middle
+		target, source := resolveAt(g, dates, days, start.Add(stop.Sub(start)/2))
+
+		if n := len(segments); n > 0 && segments[n-1].Target == target && segments[n-1].Source == source {
+			segments[n-1].Stop = stop.In(from.Location())
+			continue
+		}
+		segments = append(segments, Segment{
+			Start:  start.In(from.Location()),
+			Stop:   stop.In(from.Location()),
+			Target: target,
+			Source: source,
+		})
+	}
+	return segments
+}
+
+// datesInRange returns, in their original order, the Dates
+// which may be active at some point in the given range
+func datesInRange(dates []Date, from, to time.Time) (ret []Date) {
+	for _, d := range dates {
+		if d.Date.Add(d.Time).Add(time.Second).After(from) && d.Date.Add(-time.Second).Before(to) {
+			ret = append(ret, d)
+		}
+	}
+	return
+}
+
+// scheduleBounds returns, in chronological order, the bounds of
+// the range and every instant within it at which a Date or Day
+// starts or stops.  Between any two consecutive bounds, the set of
+// active entries is constant.
+func scheduleBounds(dates []Date, days []Day, from, to time.Time) []time.Time {
+	bounds := []time.Time{from, to}
+	addBound := func(t time.Time) {
+		if t.After(from) && t.Before(to) {
+			bounds = append(bounds, t)
+		}
+	}
+	for _, d := range dates {
+		addBound(d.Date)
+		addBound(d.Date.Add(d.Time))
+	}
+	for _, d := range days {
+		for _, i := range d.Instances(from, to) {
+			addBound(i.Start)
+			addBound(i.Stop)
+		}
+	}
+	sort.Sort(byTime(bounds))
+	return bounds
+}
+
+// byTime sorts a list of times chronologically
+type byTime []time.Time
+
+func (b byTime) Len() int           { return len(b) }
+func (b byTime) Less(i, j int) bool { return b[i].Before(b[j]) }
+func (b byTime) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// resolveAt returns the target, and the layer which supplied it,
+// for the given schedule at the given time.  It mirrors getTarget
+// for a schedule which has already been loaded.
+func resolveAt(g *Group, dates []Date, days []Day, t time.Time) (target, layer string) {
+	for _, d := range dates {
+		if d.ActiveAt(t) {
+			if d.Target != "" {
+				return d.Target, layerDate
+			}
+			break
+		}
+	}
+	for _, d := range days {
+		if d.ActiveAt(t) {
+			if d.Target != "" {
+				return d.Target, layerDay
+			}
+			break
+		}
+	}
+	if g.DefaultTarget != "" {
+		return g.DefaultTarget, layerDefault
+	}
+	return "", layerNone
+}
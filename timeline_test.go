@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBuildTimeline(t *testing.T) {
+	g := &Group{
+		ID:            "testTimelineGroup",
+		Location:      locString,
+		DefaultTarget: "100",
+	}
+	days := []Day{
+		Day{
+			Group:    g.ID,
+			Target:   "200",
+			Day:      time.Saturday,
+			Start:    1 * time.Hour, // 01:00
+			Duration: 4 * time.Hour, // until 05:00
+			Location: locString,
+		},
+		Day{
+			Group:    g.ID,
+			Target:   "201",
+			Day:      time.Sunday,
+			Start:    9 * time.Hour, // 09:00
+			Duration: 8 * time.Hour, // until 17:00
+			Location: locString,
+		},
+	}
+	dates := []Date{
+		Date{
+			Group:  g.ID,
+			Target: "300",
+			Date:   time.Date(2016, 03, 12, 3, 0, 0, 0, loc),
+			Time:   4 * time.Hour,
+		},
+	}
+
+	Convey("Given a weekend schedule with a Date override, over the DST weekend of Mar 12-13, 2016", t, func() {
+		from := time.Date(2016, 03, 12, 0, 0, 0, 0, loc)
+		to := time.Date(2016, 03, 14, 0, 0, 0, 0, loc)
+		segs := buildTimeline(g, dates, days, from, to)
+
+		Convey("The segments should be contiguous and cover the range", func() {
+			So(segs, ShouldNotBeEmpty)
+			So(segs[0].Start, ShouldHappenOnOrBefore, from)
+			So(segs[len(segs)-1].Stop, ShouldHappenOnOrAfter, to)
+			for i := 1; i < len(segs); i++ {
+				So(segs[i].Start.Equal(segs[i-1].Stop), ShouldBeTrue)
+			}
+		})
+
+		Convey("The segments should follow Date, Day, default precedence", func() {
+			So(segs, ShouldHaveLength, 6)
+			So(segs[0].Source, ShouldEqual, layerDefault)
+			So(segs[1].Source, ShouldEqual, layerDay)
+			So(segs[1].Target, ShouldEqual, "200")
+			So(segs[2].Source, ShouldEqual, layerDate)
+			So(segs[2].Target, ShouldEqual, "300")
+			So(segs[2].Stop.Equal(time.Date(2016, 03, 12, 7, 0, 0, 0, loc)), ShouldBeTrue)
+			So(segs[3].Source, ShouldEqual, layerDefault)
+			So(segs[4].Target, ShouldEqual, "201")
+		})
+
+		Convey("The Sunday shift should start at 09:00 local time, after the DST transition", func() {
+			So(segs[4].Start.Hour(), ShouldEqual, 9)
+			So(segs[4].Stop.Hour(), ShouldEqual, 17)
+			So(segs[4].Start.Equal(time.Date(2016, 03, 13, 9, 0, 0, 0, loc)), ShouldBeTrue)
+		})
+
+		Convey("Each segment should agree with resolveAt", func() {
+			for _, s := range segs {
+				target, source := resolveAt(g, dates, days, s.Start.Add(time.Minute))
+				So(target, ShouldEqual, s.Target)
+				So(source, ShouldEqual, s.Source)
+			}
+		})
+	})
+
+	Convey("Given a group with no schedule and no default target", t, func() {
+		empty := &Group{ID: "testEmptyTimelineGroup", Location: locString}
+		from := time.Date(2016, 03, 12, 0, 0, 0, 0, loc)
+		segs := buildTimeline(empty, nil, nil, from, from.Add(24*time.Hour))
+
+		Convey("There should be a single segment with no target", func() {
+			So(segs, ShouldHaveLength, 1)
+			So(segs[0].Source, ShouldEqual, layerNone)
+			So(segs[0].Target, ShouldBeBlank)
+		})
+	})
+}
+
+func TestParseRange(t *testing.T) {
+	Convey("Given only a start time", t, func() {
+		from, to, err := parseRange("2016-03-12T00:00:00-05:00", "", 24*time.Hour)
+
+		Convey("The range should last for the default span", func() {
+			So(err, ShouldBeNil)
+			So(to.Sub(from), ShouldEqual, 24*time.Hour)
+		})
+	})
+	Convey("Given a stop time before the start time", t, func() {
+		_, _, err := parseRange("2016-03-12T00:00:00-05:00", "2016-03-11T00:00:00-05:00", 24*time.Hour)
+
+		Convey("Parsing should fail", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}